@@ -1,8 +1,10 @@
 package main
 
 import (
+	"encoding/base64"
 	"flag"
 	"fmt"
+	"image"
 	"log"
 	"math/rand"
 	"os"
@@ -35,6 +37,24 @@ var (
 	VV          bool
 	Seed        int64
 	Shapes      string
+	Blend       string
+	Symmetry    string
+	Symmetrize  bool
+	Tile        bool
+	FreeAlpha   bool
+	Linear      bool
+	Opaque      string
+	Init        string
+	Dither      string
+	Page        string
+	Pens        string
+	Feed        float64
+	Hatch       float64
+	Layers      int
+	Precision   int
+	SVGAnim     string
+	PrimBits    int
+	LottieAnim  string
 )
 
 /*
@@ -54,6 +74,15 @@ Example configs.
 
 {"BasicShapes":{"T":0,"Mask":11}}
 
+{"SelectedShapes":{"Shapes":[
+    {"RegularPolygon":{"Sides":3}},
+    {"Star":{"Points":6,"MaxRadius":20}}
+]}}
+
+{"SelectedShapes":{"Shapes":[
+    {"Sprite":{"Dir":"icons/","MinSize":4,"MaxSize":48}}
+]}}
+
 */
 
 type flagArray []string
@@ -73,6 +102,7 @@ type shapeConfig struct {
 	Alpha  int
 	Repeat int
 	Shapes string
+	Blend  string
 }
 
 type shapeConfigArray []shapeConfig
@@ -83,7 +113,7 @@ func (i *shapeConfigArray) String() string {
 
 func (i *shapeConfigArray) Set(value string) error {
 	n, _ := strconv.ParseInt(value, 0, 0)
-	*i = append(*i, shapeConfig{int(n), Mode, Alpha, Repeat, ""})
+	*i = append(*i, shapeConfig{int(n), Mode, Alpha, Repeat, "", Blend})
 	return nil
 }
 
@@ -91,19 +121,37 @@ func init() {
 	flag.StringVar(&Input, "i", "", "input image path")
 	flag.Var(&Outputs, "o", "output image path")
 	flag.Var(&Configs, "n", "number of primitives")
-	flag.StringVar(&Background, "bg", "", "background color (hex)")
+	flag.StringVar(&Background, "bg", "", "background color (hex), transparent, or a fitted gradient, radial or quad")
 	flag.IntVar(&Alpha, "a", 0, "alpha value")
 	flag.IntVar(&InputSize, "r", 256, "resize large input images to this size")
 	flag.IntVar(&OutputSize, "s", 1024, "output image size")
-	flag.IntVar(&Mode, "m", 1, "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=line 7=beziers 8=rotatedellipse 9=polygon")
+	flag.IntVar(&Mode, "m", 1, "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=quadratic 7=cubic 8=line 9=rotatedellipse 10=polygon 11=brushstroke 12=regularpolygon 13=star")
 	flag.IntVar(&Workers, "j", 0, "number of parallel workers (default uses all cores)")
 	flag.IntVar(&Nth, "nth", 1, "save every Nth frame (put \"%d\" in path)")
 	flag.IntVar(&Repeat, "rep", 0, "add N extra shapes per iteration with reduced search")
 	flag.BoolVar(&V, "v", false, "verbose")
 	flag.BoolVar(&VV, "vv", false, "very verbose")
 	flag.Int64Var(&Seed, "seed", 0, "RNG seed")
-	flag.StringVar(&ColorPicker, "color", "", "Color picker to use")
+	flag.StringVar(&ColorPicker, "color", "", "color picker: greyscale, alpha, palette1, auto:N, @palette-file or a list of hex colors")
 	flag.StringVar(&Shapes, "shapes", "", "Shape JSON data")
+	flag.StringVar(&Blend, "blend", "", "normal, multiply, screen, add, lighten, darken or difference")
+	flag.StringVar(&Symmetry, "symmetry", "", "horizontal, vertical, quad or rotational:N")
+	flag.BoolVar(&Symmetrize, "symmetrize", false, "make the target symmetric before fitting")
+	flag.BoolVar(&FreeAlpha, "freealpha", false, "with a palette, solve the best alpha for each shape")
+	flag.BoolVar(&Tile, "tile", false, "wrap shapes around the edges to make a seamless tile")
+	flag.BoolVar(&Linear, "linear", false, "composite and solve colors in linear light")
+	flag.StringVar(&Opaque, "opaque", "", "fit shapes to the opaque area of the input: restrict or weight")
+	flag.StringVar(&Init, "init", "", "starting image, or the .json, .prim or .svg output of a previous run")
+	flag.StringVar(&Dither, "dither", "", "dither raster output to the palette: floyd, atkinson or bayer")
+	flag.StringVar(&Page, "page", "", "PDF and plotter page size: a3, a4, a5, letter, legal or WxH in mm, in or pt")
+	flag.StringVar(&Pens, "pens", "", "plotter pen colors as a list of hex colors (default the palette, or black)")
+	flag.Float64Var(&Feed, "feed", 3000, "plotter drawing speed in mm per minute")
+	flag.Float64Var(&Hatch, "hatch", 1, "plotter hatching spacing in mm")
+	flag.IntVar(&Precision, "precision", 6, "decimals of coordinates in SVG and canvas output")
+	flag.StringVar(&LottieAnim, "lottie-anim", "fade", "how shapes appear in .lottie.json output: fade or scale")
+	flag.IntVar(&PrimBits, "prim-bits", 6, "bits of each coordinate in .prim output")
+	flag.StringVar(&SVGAnim, "svg-anim", "", "animate SVG output like the GIF: pop or fade")
+	flag.IntVar(&Layers, "layers", 4, "number of layers of .layers.svg output, unless the color picker is a palette")
 }
 
 func errorMessage(message string) bool {
@@ -135,11 +183,28 @@ func main() {
 		Configs[0].Alpha = Alpha
 		Configs[0].Repeat = Repeat
 		Configs[0].Shapes = Shapes
+		Configs[0].Blend = Blend
 	}
-	for _, config := range Configs {
+	factories := make([]shape.ShapeFactory, len(Configs))
+	for i, config := range Configs {
 		if config.Count < 1 {
 			ok = errorMessage("ERROR: number argument must be > 0")
 		}
+		if config.Shapes != "" {
+			factory, err := shape.UnmarshalShapeFactory(config.Shapes)
+			if err == nil {
+				err = shape.LoadMasks(factory, primitive.LoadImage)
+			}
+			if err != nil {
+				ok = errorMessage("ERROR: invalid shapes: " + err.Error())
+			}
+			factories[i] = factory
+		}
+		if mode, err := primitive.ParseBlendMode(config.Blend); err != nil {
+			ok = errorMessage("ERROR: " + err.Error())
+		} else if Linear && mode != primitive.BlendNormal {
+			ok = errorMessage("ERROR: blend modes are not supported with linear")
+		}
 	}
 	if !ok {
 		fmt.Println("Usage: primitive [OPTIONS] -i input -o output -n count")
@@ -181,12 +246,21 @@ func main() {
 
 	// determine background color
 	var bg primitive.Color
+	var gradient primitive.GradientMode
+	fitGradient := false
 	if Background == "" {
 		plog.Log(1, "Setting backgroud to average color\n")
 		bg = primitive.MakeColor(primitive.AverageImageColor(input))
 	} else if Background == "top" {
 		plog.Log(1, "Setting backgroud to most frequent color\n")
 		bg = primitive.MakeColor(primitive.MostFrequentImageColor(input))
+	} else if mode, ok := primitive.ParseGradientMode(Background); ok {
+		plog.Log(1, "Setting backgroud to fitted %s\n", Background)
+		bg = primitive.MakeColor(primitive.AverageImageColor(input))
+		gradient, fitGradient = mode, true
+	} else if Background == "transparent" {
+		plog.Log(1, "Setting backgroud to transparent\n")
+		bg = primitive.Color{}
 	} else if Background == "center" {
 		plog.Log(1, "Setting backgroud to center color\n")
 		b := input.Bounds()
@@ -196,21 +270,161 @@ func main() {
 		bg = primitive.MakeHexColor(Background)
 	}
 
+	// load the starting canvas, if any
+	var initImage image.Image
+	var initResult *primitive.Result
+	switch strings.ToLower(filepath.Ext(Init)) {
+	case "":
+	case ".json", ".prim", ".svg", ".svgz":
+		plog.Log(1, "starting from %s\n", Init)
+		initResult, err = primitive.LoadResult(Init)
+		check(err)
+		bg = initResult.Background
+	default:
+		plog.Log(1, "starting from %s\n", Init)
+		initImage, err = primitive.LoadImage(Init)
+		check(err)
+	}
+
 	// run algorithm
-	model := primitive.NewModel(input, bg, OutputSize, primitive.MakeColorPicker(ColorPicker))
+	symmetry, err := primitive.ParseSymmetry(Symmetry)
+	check(err)
+	opaque, err := primitive.ParseOpaqueMode(Opaque)
+	check(err)
+	pageWidth, pageHeight, err := primitive.ParsePageSize(Page)
+	check(err)
+	// extract a palette from the input image if requested
+	if strings.HasPrefix(ColorPicker, "auto:") {
+		n, err := strconv.Atoi(strings.TrimPrefix(ColorPicker, "auto:"))
+		check(err)
+		if n < 1 {
+			check(fmt.Errorf("invalid palette size: %s", ColorPicker))
+		}
+		var hexes []string
+		for _, c := range primitive.ExtractPalette(input, n) {
+			hexes = append(hexes, c.Hex())
+		}
+		ColorPicker = strings.Join(hexes, ",")
+		plog.Log(1, "palette: %s\n", ColorPicker)
+		for _, output := range Outputs {
+			if output == "-" || strings.Contains(output, "%") {
+				continue
+			}
+			path := strings.TrimSuffix(output, filepath.Ext(output)) + ".palette.txt"
+			plog.Log(1, "writing %s\n", path)
+			// one color per line, so that -color @path reads it back
+			check(primitive.SaveFile(path, strings.Join(hexes, "\n")+"\n"))
+			break
+		}
+	}
+
+	// load a palette file if requested
+	var picker primitive.ColorPicker
+	if strings.HasPrefix(ColorPicker, "@") {
+		entries, err := primitive.LoadPalette(ColorPicker[1:])
+		check(err)
+		picker = primitive.NewNamedColorPalette(entries)
+	} else {
+		picker = primitive.MakeColorPicker(ColorPicker)
+	}
+	if cp, ok := picker.(*primitive.ColorPalette); ok {
+		cp.FreeAlpha = FreeAlpha
+	}
+
+	// dither raster output to the palette if requested
+	dither, err := primitive.ParseDitherMode(Dither)
+	check(err)
+	render := func(im image.Image) image.Image {
+		return im
+	}
+	if dither != primitive.DitherNone {
+		cp, ok := picker.(*primitive.ColorPalette)
+		if !ok {
+			check(fmt.Errorf("dither requires a palette color picker"))
+		}
+		render = func(im image.Image) image.Image {
+			return primitive.Dither(im, cp.Colors(), dither)
+		}
+	}
+
+	// set up the pens of a plotter
+	if Feed <= 0 || Hatch <= 0 {
+		check(fmt.Errorf("feed and hatch must be positive"))
+	}
+	if Precision < 0 {
+		check(fmt.Errorf("invalid precision: %d", Precision))
+	}
+	shape.SVGPrecision = Precision
+	animation, err := primitive.ParseSVGAnimation(SVGAnim)
+	check(err)
+	lottieAnimation, err := primitive.ParseLottieAnimation(LottieAnim)
+	check(err)
+	if PrimBits < 2 || PrimBits > 16 {
+		check(fmt.Errorf("invalid number of prim bits: %d", PrimBits))
+	}
+	if Layers < 1 {
+		check(fmt.Errorf("invalid number of layers: %d", Layers))
+	}
+	plotter := &primitive.Plotter{Width: pageWidth, Height: pageHeight, Feed: Feed, Hatch: Hatch}
+	if Pens != "" {
+		for _, x := range strings.Split(Pens, ",") {
+			plotter.Pens = append(plotter.Pens, primitive.MakeHexColor(strings.TrimSpace(x)))
+		}
+	} else if cp, ok := picker.(*primitive.ColorPalette); ok {
+		plotter.Pens = cp.Colors()
+	} else {
+		plotter.Pens = []primitive.Color{{R: 0, G: 0, B: 0, A: 255}}
+	}
+
+	model := primitive.NewModel(input, bg, OutputSize, picker)
+	model.SetSymmetry(symmetry, Symmetrize)
+	model.SetTile(Tile)
+	if fitGradient {
+		model.SetGradient(primitive.FitGradient(model.Target, gradient))
+	}
+	if initResult != nil && initResult.Gradient != nil {
+		model.SetGradient(initResult.Gradient)
+	}
+	if initImage != nil {
+		model.SetInit(initImage)
+	}
+	model.SetOpaque(opaque)
+	model.Linear = Linear
 	model.Init(Workers, Seed)
+	if initResult != nil {
+		check(model.AddResult(initResult))
+	}
+
+	// the layers of a cut file are the palette, or the main colors of the
+	// output
+	layers := func() *primitive.ColorPalette {
+		if cp, ok := picker.(*primitive.ColorPalette); ok {
+			return cp
+		}
+		var hexes []string
+		for _, c := range primitive.ExtractPalette(model.Context.Image(), Layers) {
+			hexes = append(hexes, c.Hex())
+		}
+		return primitive.NewColorPalette(hexes)
+	}
+	svg := func() string {
+		if animation != primitive.SVGAnimationNone {
+			return model.AnimatedSVG(animation, 0.001, 50, 250)
+		}
+		return model.SVG()
+	}
+
 	plog.Log(1, "%d: t=%.3f, score=%.6f\n", 0, 0.0, model.Score)
 	start := time.Now()
 	frame := 0
 	for j, config := range Configs {
-		plog.Log(1, "count=%d, mode=%d, alpha=%d, repeat=%d\n",
-			config.Count, config.Mode, config.Alpha, config.Repeat)
+		plog.Log(1, "count=%d, mode=%d, alpha=%d, repeat=%d, blend=%s\n",
+			config.Count, config.Mode, config.Alpha, config.Repeat, config.Blend)
+		model.Blend, _ = primitive.ParseBlendMode(config.Blend)
 
-		var factory shape.ShapeFactory = nil
-		if config.Shapes != "" {
-			factory = shape.UnmarshalShapeFactory(config.Shapes)
-		} else {
-			// "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=line 7=beziers 8=rotatedellipse 9=polygon"
+		factory := factories[j]
+		if factory == nil {
+			// "0=combo 1=triangle 2=rect 3=ellipse 4=circle 5=rotatedrect 6=quadratic 7=cubic 8=line 9=rotatedellipse 10=polygon 11=brushstroke 12=regularpolygon 13=star"
 			// TODO: Multiple Shapes for a BasicShapeFactory.
 			factory = shape.NewBasicShapeFactory([]shape.ShapeType{shape.ShapeType(config.Mode)})
 		}
@@ -247,13 +461,49 @@ func main() {
 					default:
 						check(fmt.Errorf("unrecognized file extension: %s", ext))
 					case ".png":
-						check(primitive.SavePNG(path, model.Context.Image()))
+						check(primitive.SavePNG(path, render(model.Context.Image())))
 					case ".jpg", ".jpeg":
-						check(primitive.SaveJPG(path, model.Context.Image(), 95))
+						check(primitive.SaveJPG(path, render(model.Context.Image()), 95))
 					case ".svg":
-						check(primitive.SaveFile(path, model.SVG()))
+						if strings.HasSuffix(strings.ToLower(path), ".layers.svg") {
+							check(primitive.SaveFile(path, model.LayeredSVG(layers())))
+						} else {
+							check(primitive.SaveFile(path, svg()))
+						}
+					case ".svgz":
+						check(primitive.SaveGzipFile(path, svg()))
+					case ".js", ".html":
+						var js string
+						if ext == ".html" {
+							js = model.CanvasHTML()
+						} else {
+							js = model.Canvas()
+						}
+						check(primitive.SaveFile(path, js))
+						plog.Log(0, "%s: %d bytes, %d gzipped\n", path, len(js), primitive.GzipSize(js))
+					case ".json":
+						if strings.HasSuffix(strings.ToLower(path), ".lottie.json") {
+							check(primitive.SaveFile(path, model.Lottie(lottieAnimation, 0.001, 50, 250)))
+						} else {
+							check(primitive.SaveFile(path, model.JSON()))
+						}
+					case ".prim":
+						data := model.Prim(PrimBits)
+						check(primitive.SaveFile(path, string(data)))
+						plog.Log(0, "%s: %d bytes, %d in base64\n", path, len(data), base64.StdEncoding.EncodedLen(len(data)))
+					case ".pdf":
+						check(primitive.SaveFile(path, string(model.PDF(pageWidth, pageHeight))))
+					case ".eps":
+						check(primitive.SaveFile(path, model.EPS()))
+					case ".hpgl", ".plt":
+						check(primitive.SaveFile(path, model.HPGL(plotter)))
+					case ".gcode", ".nc":
+						check(primitive.SaveFile(path, model.GCode(plotter)))
 					case ".gif":
 						frames := model.Frames(0.001)
+						for i := range frames {
+							frames[i] = render(frames[i])
+						}
 						check(primitive.SaveGIFImageMagick(path, frames, 50, 250))
 					}
 				}
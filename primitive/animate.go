@@ -0,0 +1,85 @@
+package primitive
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SVGAnimation is how shapes appear in animated SVG output.
+type SVGAnimation int
+
+const (
+	SVGAnimationNone SVGAnimation = iota
+	SVGAnimationPop               // shapes appear at once
+	SVGAnimationFade              // shapes fade in over a frame
+)
+
+// ParseSVGAnimation parses the -svg-anim flag.
+func ParseSVGAnimation(name string) (SVGAnimation, error) {
+	switch name {
+	case "", "none":
+		return SVGAnimationNone, nil
+	case "pop":
+		return SVGAnimationPop, nil
+	case "fade":
+		return SVGAnimationFade, nil
+	}
+	return SVGAnimationNone, fmt.Errorf("unrecognized svg animation: %s", name)
+}
+
+// percent formats the fraction as a percentage for a keyframe.
+func percent(x float64) string {
+	s := strconv.FormatFloat(clamp(x, 0, 1)*100, 'f', 3, 64)
+	return strings.TrimRight(strings.TrimRight(s, "0"), ".") + "%"
+}
+
+// frameEnds returns the number of shapes drawn in each frame of Frames
+// after the background. Shapes added after the last frame are drawn in
+// another, so that the animation ends on the finished image.
+func (model *Model) frameEnds(scoreDelta float64) []int {
+	var frames []int
+	previous := 10.0
+	for i, s := range model.Shapes {
+		if previous-s.Score >= scoreDelta {
+			previous = s.Score
+			frames = append(frames, i+1)
+		}
+	}
+	if n := len(model.Shapes); n > 0 && (len(frames) == 0 || frames[len(frames)-1] != n) {
+		frames = append(frames, n)
+	}
+	return frames
+}
+
+// AnimatedSVG returns the model as an SVG in which the shapes appear in
+// the order they were added, with CSS animations which loop forever. The
+// shapes are split into frames as by Frames, and the timing is that of
+// SaveGIFImageMagick: each frame is shown for delay hundredths of a
+// second and the last for lastDelay. Viewers without CSS animations show
+// the finished image.
+func (model *Model) AnimatedSVG(mode SVGAnimation, scoreDelta float64, delay, lastDelay int) string {
+	frames := model.frameEnds(scoreDelta)
+
+	// frame N is shown after the background and N-1 frames before it
+	total := float64(len(frames)*delay + lastDelay)
+	var style []string
+	if mode == SVGAnimationFade {
+		style = append(style, fmt.Sprintf("g[class]{animation:%ss linear infinite}", strconv.FormatFloat(total/100, 'g', -1, 64)))
+	} else {
+		style = append(style, fmt.Sprintf("g[class]{animation:%ss step-end infinite}", strconv.FormatFloat(total/100, 'g', -1, 64)))
+	}
+	for i := range frames {
+		start := float64((i+1)*delay) / total
+		name := fmt.Sprintf("f%d", i+1)
+		if mode == SVGAnimationFade {
+			end := float64((i+2)*delay) / total
+			style = append(style, fmt.Sprintf(".%s{animation-name:%s}@keyframes %s{0%%,%s{opacity:0}%s,100%%{opacity:1}}",
+				name, name, name, percent(start), percent(end)))
+		} else {
+			style = append(style, fmt.Sprintf(".%s{animation-name:%s}@keyframes %s{0%%{visibility:hidden}%s,100%%{visibility:visible}}",
+				name, name, name, percent(start)))
+		}
+	}
+	return model.svg(frames, strings.Join(style, "\n"))
+}
@@ -0,0 +1,280 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// BlendMode is how a shape's color is combined with the pixels under it.
+// Every mode is composited like normal (source-over) blending, but with the
+// color B(backdrop, source) in place of the source color.
+type BlendMode int
+
+const (
+	BlendNormal BlendMode = iota
+	BlendMultiply
+	BlendScreen
+	BlendAdd
+	BlendLighten
+	BlendDarken
+	BlendDifference
+)
+
+var blendNames = []string{"normal", "multiply", "screen", "add", "lighten", "darken", "difference"}
+
+// ParseBlendMode parses the -blend flag.
+func ParseBlendMode(name string) (BlendMode, error) {
+	if name == "" {
+		return BlendNormal, nil
+	}
+	for i, n := range blendNames {
+		if n == name {
+			return BlendMode(i), nil
+		}
+	}
+	return BlendNormal, fmt.Errorf("unrecognized blend mode: %s", name)
+}
+
+func (b BlendMode) String() string {
+	return blendNames[b]
+}
+
+// css returns the CSS mix-blend-mode of the blend mode.
+func (b BlendMode) css() string {
+	if b == BlendAdd {
+		return "plus-lighter"
+	}
+	return b.String()
+}
+
+// apply returns the blended value of a channel with backdrop d and source s.
+func (b BlendMode) apply(d, s int) int {
+	switch b {
+	case BlendMultiply:
+		return (d*s + 127) / 255
+	case BlendScreen:
+		return d + s - (d*s+127)/255
+	case BlendAdd:
+		return minInt(d+s, 255)
+	case BlendLighten:
+		return maxInt(d, s)
+	case BlendDarken:
+		return minInt(d, s)
+	case BlendDifference:
+		if d > s {
+			return d - s
+		}
+		return s - d
+	}
+	return s
+}
+
+// drawLinesBlend is drawLines with a blend mode.
+func drawLinesBlend(im *image.RGBA, c Color, lines []shape.Scanline, mode BlendMode) {
+	if mode == BlendNormal {
+		drawLines(im, c, lines)
+		return
+	}
+	const m = 0xffff
+	sa := uint32(c.A) * 0x101
+	for _, line := range lines {
+		a := int(sa * line.Alpha / m)
+		i := im.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k, s := range [3]int{c.R, c.G, c.B} {
+				d := int(im.Pix[i+k])
+				im.Pix[i+k] = uint8(d + (mode.apply(d, s)-d)*a/m)
+			}
+			da := int(im.Pix[i+3])
+			im.Pix[i+3] = uint8(da + (255-da)*a/m)
+			i += 4
+		}
+	}
+}
+
+// blendImage composites the color c onto dst with a blend mode, using the
+// alpha of layer, which is the shape drawn in c, as the coverage.
+func blendImage(dst, layer *image.RGBA, c Color, mode BlendMode) {
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := int(layer.Pix[i+3])
+		if a == 0 {
+			continue
+		}
+		for k, s := range [3]int{c.R, c.G, c.B} {
+			d := int(dst.Pix[i+k])
+			dst.Pix[i+k] = uint8(d + (mode.apply(d, s)-d)*a/255)
+		}
+		da := int(dst.Pix[i+3])
+		dst.Pix[i+3] = uint8(da + (255-da)*a/255)
+	}
+}
+
+// BlendPicker is implemented by color pickers which can choose colors for
+// blend modes other than normal.
+type BlendPicker interface {
+	SelectBlend(target, current *image.RGBA, lines []shape.Scanline, alpha int, mode BlendMode) Color
+}
+
+// selectColor picks the color for the lines with the picker, taking the
+// blend mode into account when the picker supports it.
+func selectColor(picker ColorPicker, mode BlendMode, target, current *image.RGBA, lines []shape.Scanline, alpha int) Color {
+	if mode != BlendNormal {
+		if p, ok := picker.(BlendPicker); ok {
+			return p.SelectBlend(target, current, lines, alpha, mode)
+		}
+	}
+	return picker.Select(target, current, lines, alpha)
+}
+
+// blendStats summarizes the pixels under a shape by channel and current
+// value, so that the error of drawing any color with a blend mode can be
+// found without visiting the pixels again. Drawing at alpha a over a pixel
+// with coverage m and current value v changes it by a*m*delta, where
+// delta = B(v, s) - v. With e = target - v, summing (e - a*m*delta)^2 over
+// the pixels sharing v gives sum(e^2) - 2*a*delta*ME + a^2*delta^2*MM,
+// where ME = sum(m*e) and MM = sum(m^2).
+type blendStats struct {
+	mode   BlendMode
+	values [3][]int // the distinct current values of each channel
+	me, mm [3][256]float64
+}
+
+func newBlendStats(target, current *image.RGBA, lines []shape.Scanline, mode BlendMode) *blendStats {
+	b := &blendStats{mode: mode}
+	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k := 0; k < 3; k++ {
+				v := current.Pix[i+k]
+				if b.mm[k][v] == 0 {
+					b.values[k] = append(b.values[k], int(v))
+				}
+				b.me[k][v] += m * float64(int(target.Pix[i+k])-int(v))
+				b.mm[k][v] += m * m
+			}
+			i += 4
+		}
+	}
+	return b
+}
+
+// channelError returns the change in squared error of channel k when it is
+// drawn with value s at alpha a.
+func (b *blendStats) channelError(k, s int, a float64) float64 {
+	var e float64
+	for _, v := range b.values[k] {
+		d := a * float64(b.mode.apply(v, s)-v)
+		e += d * (d*b.mm[k][v] - 2*b.me[k][v])
+	}
+	return e
+}
+
+// colorError returns the change in squared error when c is drawn at its
+// own alpha.
+func (b *blendStats) colorError(c Color) float64 {
+	a := float64(c.A) / 255
+	return b.channelError(0, c.R, a) + b.channelError(1, c.G, a) + b.channelError(2, c.B, a)
+}
+
+// bestAlpha returns the alpha which minimizes the error of drawing c.
+func (b *blendStats) bestAlpha(c Color) int {
+	var num, den float64
+	for k, s := range [3]int{c.R, c.G, c.B} {
+		for _, v := range b.values[k] {
+			d := float64(b.mode.apply(v, s) - v)
+			num += d * b.me[k][v]
+			den += d * d * b.mm[k][v]
+		}
+	}
+	if den == 0 {
+		return 255
+	}
+	return clampInt(int(num/den*255+0.5), 1, 255)
+}
+
+// searchValue returns the value in [0, 255] minimizing f, searching
+// coarsely and then finely around the best value found. The blend modes
+// make f piecewise smooth, so this finds the minimum or comes close.
+func searchValue(f func(int) float64) int {
+	best, bestError := 0, math.Inf(1)
+	try := func(s int) {
+		if s < 0 || s > 255 {
+			return
+		}
+		if e := f(s); e < bestError {
+			best, bestError = s, e
+		}
+	}
+	for s := 0; s < 256; s += 17 {
+		try(s)
+	}
+	for _, step := range []int{4, 1} {
+		center := best
+		for i := -3; i <= 3; i++ {
+			try(center + i*step)
+		}
+	}
+	return best
+}
+
+func (s *BestColor) SelectBlend(target, current *image.RGBA, lines []shape.Scanline, alpha int, mode BlendMode) Color {
+	b := newBlendStats(target, current, lines, mode)
+	return b.bestColor(alpha)
+}
+
+func (b *blendStats) bestColor(alpha int) Color {
+	a := float64(alpha) / 255
+	var rgb [3]int
+	for k := range rgb {
+		k := k
+		rgb[k] = searchValue(func(s int) float64 {
+			return b.channelError(k, s, a)
+		})
+	}
+	return Color{rgb[0], rgb[1], rgb[2], alpha}
+}
+
+func (s *BestGreyscale) SelectBlend(target, current *image.RGBA, lines []shape.Scanline, alpha int, mode BlendMode) Color {
+	b := newBlendStats(target, current, lines, mode)
+	a := float64(alpha) / 255
+	bw := searchValue(func(s int) float64 {
+		return b.channelError(0, s, a) + b.channelError(1, s, a) + b.channelError(2, s, a)
+	})
+	return Color{bw, bw, bw, alpha}
+}
+
+func (s *BestAlpha) SelectBlend(target, current *image.RGBA, lines []shape.Scanline, alpha int, mode BlendMode) Color {
+	// alternately solve for the color and the alpha
+	b := newBlendStats(target, current, lines, mode)
+	c := b.bestColor(128)
+	for i := 0; i < 2; i++ {
+		c = b.bestColor(b.bestAlpha(c))
+	}
+	return c
+}
+
+func (cp *ColorPalette) SelectBlend(target, current *image.RGBA, lines []shape.Scanline, alpha int, mode BlendMode) Color {
+	b := newBlendStats(target, current, lines, mode)
+	candidates := []int{0}
+	if len(cp.rgbColors) > 1 {
+		candidates = cp.nearest(b.bestColor(alpha), paletteCandidates)
+	}
+	var result Color
+	bestError := math.Inf(1)
+	for _, i := range candidates {
+		c := cp.rgbColors[i]
+		c.A = alpha
+		if cp.FreeAlpha {
+			c.A = b.bestAlpha(c)
+		}
+		if e := b.colorError(c); e < bestError {
+			result = c
+			bestError = e
+		}
+	}
+	return result
+}
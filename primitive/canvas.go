@@ -0,0 +1,175 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// canvasScript draws the output on the canvas with the id "primitive". S
+// is the list of styles, each a color, a line width if the shapes are
+// stroked, and a composite operation. D is the list of shapes, each the
+// index of a style or a shape drawn in the last style: a path in SVG
+// syntax, or the center, radii and rotation of an ellipse.
+const canvasScript = `(function(c){var x=c.getContext("2d"),S=%s,D=%s,s,p,q,i;c.width=%d;c.height=%d;` +
+	`function d(){x.setTransform(%s);x.lineCap=x.lineJoin="round";` +
+	`for(i=0;i<D.length;i++){p=D[i];` +
+	`if(typeof p=="number")s=S[p],x.fillStyle=x.strokeStyle=s[0],x.lineWidth=s[1],x.globalCompositeOperation=s[2]||"source-over";` +
+	`else{if(typeof p=="string")p=new Path2D(p);else q=new Path2D,q.ellipse(p[0],p[1],p[2],p[3],p[4],0,7),p=q;` +
+	`s[1]?x.stroke(p):x.fill(p)}}}` +
+	`%s})(document.getElementById("primitive"))`
+
+// canvasOp returns the canvas composite operation of the blend mode.
+func (b BlendMode) canvasOp() string {
+	if b == BlendAdd {
+		return "lighter"
+	}
+	return b.String()
+}
+
+// canvasColor formats the color as hex, with the alpha if it is not
+// opaque.
+func canvasColor(c Color) string {
+	if c.A >= 255 {
+		return c.Hex()
+	}
+	return fmt.Sprintf("%s%02x", c.Hex(), c.A)
+}
+
+// canvasPath formats the path, transformed, in the SVG path syntax which
+// Path2D accepts, to shape.SVGPrecision decimals and with no space where
+// a minus sign separates the numbers.
+func canvasPath(p shape.Path, t affine) string {
+	ops := [...]string{"M", "L", "Q", "C", "Z"}
+	points := [...]int{1, 1, 2, 3, 0}
+	var b strings.Builder
+	for _, c := range p.Commands {
+		b.WriteString(ops[c.Op])
+		for i := 0; i < points[c.Op]; i++ {
+			x, y := t.apply(c.P[i][0], c.P[i][1])
+			for j, v := range [2]float64{x, y} {
+				s := shape.SVGNum(v)
+				if (i > 0 || j > 0) && s[0] != '-' {
+					b.WriteByte(' ')
+				}
+				b.WriteString(s)
+			}
+		}
+	}
+	return b.String()
+}
+
+// canvasShape returns the shape, transformed, as an element of D.
+// Ellipses are drawn with the ellipse of the canvas, which is shorter
+// than their path; the transforms of copies only turn and mirror them,
+// so the transformed ellipse is the one about the transformed axis.
+func canvasShape(s shape.Shape, path shape.Path, t affine) string {
+	if c, ok := s.(*shape.Constrained); ok {
+		s = c.Shape
+	}
+	var x, y, rx, ry, angle float64
+	switch s := s.(type) {
+	case *shape.Ellipse:
+		x, y, rx, ry = float64(s.X), float64(s.Y), float64(s.Rx), float64(s.Ry)
+	case *shape.RotatedEllipse:
+		x, y, rx, ry, angle = s.X, s.Y, s.Rx, s.Ry, s.Angle*math.Pi/180
+	default:
+		return strconv.Quote(canvasPath(path, t))
+	}
+	x, y = t.apply(x, y)
+	angle = math.Atan2(t[3]*math.Cos(angle)+t[4]*math.Sin(angle), t[0]*math.Cos(angle)+t[1]*math.Sin(angle))
+	return "[" + strings.Join([]string{
+		shape.SVGNum(x), shape.SVGNum(y), shape.SVGNum(rx), shape.SVGNum(ry),
+		strconv.FormatFloat(angle, 'f', 3, 64)}, ",") + "]"
+}
+
+// canvasImage returns the image as a PNG data URL.
+func canvasImage(im image.Image) string {
+	var buf bytes.Buffer
+	png.Encode(&buf, im)
+	return "data:image/png;base64," + base64.StdEncoding.EncodeToString(buf.Bytes())
+}
+
+// Canvas returns the model as a script which draws it with canvas 2D
+// paths on the canvas with the id "primitive", sizing the canvas to the
+// output. The shapes are packed into an array of paths, ellipses and
+// styles, with each copy of a shape drawn on its own as by Draw. A
+// background image is drawn before the shapes once it has loaded.
+// Sprites, which have no outline, and the mask of the opaque area are
+// not drawn.
+func (model *Model) Canvas() string {
+	var styles, shapes []string
+	index := make(map[string]int)
+	current := -1
+	skipped := 0
+	for _, s := range model.Shapes {
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		style := []string{strconv.Quote(canvasColor(s.Color))}
+		if path.Width > 0 || s.Blend != BlendNormal {
+			style = append(style, shape.SVGNum(path.Width))
+		}
+		if s.Blend != BlendNormal {
+			style = append(style, strconv.Quote(s.Blend.canvasOp()))
+		}
+		key := "[" + strings.Join(style, ",") + "]"
+		i, ok := index[key]
+		if !ok {
+			i = len(styles)
+			index[key] = i
+			styles = append(styles, key)
+		}
+		if i != current {
+			shapes = append(shapes, strconv.Itoa(i))
+			current = i
+		}
+		for _, t := range model.copies(s.Shape) {
+			shapes = append(shapes, canvasShape(s.Shape, path, t))
+		}
+	}
+	if skipped > 0 {
+		warn("canvas: %d shapes without an outline were not drawn\n", skipped)
+	}
+
+	// the background, then the shapes
+	sw, sh := model.Sw, model.Sh
+	var background string
+	var im image.Image
+	if model.initImage != nil {
+		im = model.initImage
+	} else if model.gradient != nil {
+		im = model.gradient.image(sw, sh, model.Scale, 0.5)
+	}
+	switch {
+	case im != nil:
+		background = fmt.Sprintf(`var m=new Image;m.onload=function(){x.drawImage(m,0,0,%d,%d);d()};m.src="%s"`,
+			sw, sh, canvasImage(im))
+	case model.Background.A != 0:
+		background = fmt.Sprintf(`x.fillStyle="%s";x.fillRect(0,0,%d,%d);d()`, canvasColor(model.Background), sw, sh)
+	default:
+		background = "d()"
+	}
+	k := strconv.FormatFloat(model.Scale, 'g', -1, 64)
+	h := strconv.FormatFloat(model.Scale/2, 'g', -1, 64)
+	transform := strings.Join([]string{k, "0", "0", k, h, h}, ",")
+	return fmt.Sprintf(canvasScript,
+		"["+strings.Join(styles, ",")+"]", "["+strings.Join(shapes, ",")+"]",
+		sw, sh, transform, background) + "\n"
+}
+
+// CanvasHTML returns a page with a canvas on which the script of Canvas
+// draws the model.
+func (model *Model) CanvasHTML() string {
+	return "<!DOCTYPE html>\n<canvas id=\"primitive\" style=\"width:100%\"></canvas>\n" +
+		"<script>" + model.Canvas() + "</script>\n"
+}
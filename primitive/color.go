@@ -15,6 +15,11 @@ func (c *Color) NRGBA() color.NRGBA {
 	return color.NRGBA{uint8(c.R), uint8(c.G), uint8(c.B), uint8(c.A)}
 }
 
+// Hex returns the color as a "#rrggbb" string, ignoring alpha.
+func (c *Color) Hex() string {
+	return fmt.Sprintf("#%02x%02x%02x", c.R, c.G, c.B)
+}
+
 func (c *Color) Delta(color *Color) Color {
 	x := Color{c.R - color.R, c.G - color.G, c.B - color.B, c.A - color.A}
 	if x.R < 0 {
@@ -2,8 +2,9 @@ package primitive
 
 import (
 	"image"
-	"strings"
 	"math"
+	"sort"
+	"strings"
 
 	"github.com/laramiel/primitive/primitive/shape"
 )
@@ -14,6 +15,20 @@ type ColorPicker interface {
 	Select(target, current *image.RGBA, lines []shape.Scanline, alpha int) Color
 }
 
+// AlphaSolver is implemented by color pickers which may choose the alpha of
+// the color themselves. When SolvesAlpha returns true, the alpha passed to
+// Select is ignored, so it need not be searched for.
+type AlphaSolver interface {
+	SolvesAlpha() bool
+}
+
+// ColorNamer is implemented by color pickers which know the names of the
+// colors they pick, such as palettes loaded from a file.
+type ColorNamer interface {
+	// ColorName returns the name of the color, or "" if it has none.
+	ColorName(c Color) string
+}
+
 const Palette1 = "#8b3336,#c83940,#e3acbb,#01afa4,#1ab7ad,#8cd1cc,#d1c0db,#dbc6de,#e6dbe6,#cae1a3,#cfe4a8,#e2edcb,#a59256,#94814c,#c8c7c1,#a5472e,#ac4d33,#e7ac8c,#543b34,#573c33,#aba190,#f0583d,#f26f4e,#f9bdb7,#b33835,#cd3434,#e47294,#f0553b,#f15b39,#f8a78a,#ee422e,#ef4a38,#f58d81,#faa720,#fbc017,#fdde39,#f5eb57,#f3eb5c,#f1efab,#fdbf30,#fdc01d,#fcdf76,#0875b8,#0375bb,#19c0ed,#325eab,#3363ae,#55b4e5,#e99c62,#ee9a5f,#eacebf,#603b4a,#793854,#d1a0c8,#383230,#383439,#8582bc,#e7c058,#ecd37d,#f1ebde,#135341,#076a42,#0eb69d,#039e4e,#0ea54f,#84c991,#35483b,#2e4636,#96c291,#343c3b,#494c46,#929698,#3dabc7,#43acc8,#99c9d9,#adcbea,#b6d0e9,#d2e0ef,#f8c8a3,#f8cea8,#f8e1c9,#363636,#323231,#666f74,#f284ae,#f288b1,#f7bcd4,#fcef9e,#f7ed9d,#f6f2ca,#b4babf,#b6bbc0,#dadddc,#393a3c,#373e4b,#a6b5c0,#31395c,#1869b0,#13b3e9,#0f5c4c,#0f5a48,#0db7a8,#1e4279,#2569b0,#26bcea,#d54344,#e34446,#f4aac3,#fdd220,#fcda22,#fbea8d,#805e9e,#b688bb,#ceb9d7,#a8335b,#d14b7e,#ea97c1,#a36f43,#b27647,#e1c5a3,#463d30,#5e4835,#b3ab9f,#b94a30,#c24b2f,#e99b90,#343836,#334536,#8ec0a1,#c1c9ca,#bfc4c3,#dce5e7,#e3e5e3,#e1e3e2,#e0e3e2,#2f3971,#34549e,#98bce3,#e1d5af,#e4d3ab,#eae2cd,#e2a530,#e9a636,#f0d6a8"
 
 // BestColor calculates the color that should be used with the Scanlines to
@@ -80,73 +95,181 @@ func (s *BestGreyscale) Select(target, current *image.RGBA, lines []shape.Scanli
 	return result
 }
 
+// BestAlpha solves for both the color and the alpha which best approximate
+// the target image, ignoring the alpha it is given.
 type BestAlpha struct {
 }
 
+func (s *BestAlpha) SolvesAlpha() bool {
+	return true
+}
+
 func (s *BestAlpha) Select(target, current *image.RGBA, lines []shape.Scanline, alpha int) Color {
-	var count int64
-	var ssum, hsum, csum int64
-	var rsum, gsum, bsum int64
+	var sums alphaSums
 	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
 		i := target.PixOffset(line.X1, line.Y)
 		for x := line.X1; x <= line.X2; x++ {
-			tr := int(target.Pix[i])
-			tg := int(target.Pix[i+1])
-			tb := int(target.Pix[i+2])
-			cr := int(current.Pix[i])
-			cg := int(current.Pix[i+1])
-			cb := int(current.Pix[i+2])
+			sums.add(m,
+				[3]float64{float64(target.Pix[i]), float64(target.Pix[i+1]), float64(target.Pix[i+2])},
+				[3]float64{float64(current.Pix[i]), float64(current.Pix[i+1]), float64(current.Pix[i+2])})
 			i += 4
-			rsum += int64((tr - cr))
-			ssum += int64(cr)
-			gsum += int64((tg - cg))
-			hsum += int64(cg)
-			bsum += int64((tb - cb))
-			csum += int64(cb)
-			count++
 		}
 	}
-	if count == 0 {
+	if sums.mm == 0 {
 		return Color{}
 	}
-	r := clampInt(int(rsum/count)>>8, 0, 255)
-	g := clampInt(int(gsum/count)>>8, 0, 255)
-	b := clampInt(int(bsum/count)>>8, 0, 255)
-	result := Color{r, g, b, alpha}
-	return result
+	alpha, c := sums.solve(255)
+	r := clampInt(int(c[0]+0.5), 0, 255)
+	g := clampInt(int(c[1]+0.5), 0, 255)
+	b := clampInt(int(c[2]+0.5), 0, 255)
+	return Color{r, g, b, alpha}
+}
+
+// alphaSums accumulates the pixels under a shape for BestAlpha.
+//
+// With coverage m, drawing color c at alpha a over cur gives
+// cur + m*a*(c - cur). Writing u = a*c, the error against the target,
+// sum((d - m*u + a*m*cur)^2) with d = target - cur, is linear least squares
+// in u and a. For a given a each channel is solved by u = D + a*C, where D
+// and C are the coverage-weighted means of d and cur, which leaves a single
+// quadratic in a.
+type alphaSums struct {
+	mm                 float64
+	md, mmc, mdc, mmcc [3]float64
+}
+
+func (s *alphaSums) add(m float64, target, current [3]float64) {
+	s.mm += m * m
+	for k := 0; k < 3; k++ {
+		cur := current[k]
+		d := target[k] - cur
+		s.md[k] += m * d
+		s.mmc[k] += m * m * cur
+		s.mdc[k] += m * d * cur
+		s.mmcc[k] += m * m * cur * cur
+	}
+}
+
+// solve returns the alpha and the color, with channels in [0, max], which
+// best approximate the target.
+func (s *alphaSums) solve(max float64) (int, [3]float64) {
+	var num, den float64
+	var D, C [3]float64
+	for k := 0; k < 3; k++ {
+		D[k] = s.md[k] / s.mm
+		C[k] = s.mmc[k] / s.mm
+		num += s.mm*D[k]*C[k] - s.mdc[k]
+		den += s.mmcc[k] - s.mm*C[k]*C[k]
+	}
+	a := 0.5
+	if den > 1e-9*max*max {
+		a = num / den
+	}
+	// the color c = C + D/a must stay in range, which bounds a from below
+	for k := 0; k < 3; k++ {
+		if D[k] > 0 && C[k] < max {
+			a = math.Max(a, D[k]/(max-C[k]))
+		} else if D[k] < 0 && C[k] > 0 {
+			a = math.Max(a, -D[k]/C[k])
+		}
+	}
+	alpha := clampInt(int(a*255+0.5), 1, 255)
+	a = float64(alpha) / 255
+	var c [3]float64
+	for k := range c {
+		c[k] = clamp(C[k]+D[k]/a, 0, max)
+	}
+	return alpha, c
 }
 
-// ColorPalette allows you to restrict to a certain range of colors
+// paletteCandidates is the number of palette colors, nearest to the best
+// unrestricted color, which are tried for each shape.
+const paletteCandidates = 8
+
+// ColorPalette allows you to restrict to a certain range of colors. The
+// palette colors nearest to the best color are each tried at the requested
+// alpha, and the one which leaves the least error against the target wins.
+// With FreeAlpha set, the best alpha for each palette color is solved for
+// instead.
 type ColorPalette struct {
+	FreeAlpha  bool
 	hexStrings []string
 	rgbColors  []Color
+	names      []string
 	b          BestColor
 }
 
-// closestColor returns the index of the closest color.
-func (cp *ColorPalette) closestColorIdx(c Color) int {
-	selected := 0
-	score := 100000.0
+func (cp *ColorPalette) SolvesAlpha() bool {
+	return cp.FreeAlpha
+}
+
+// nearest returns the indices of up to k palette colors, closest to c first.
+func (cp *ColorPalette) nearest(c Color, k int) []int {
+	dist := make([]int, len(cp.rgbColors))
+	indices := make([]int, len(cp.rgbColors))
 	for i, cmp := range cp.rgbColors {
 		d := c.Delta(&cmp)
-		// minimize the euclidian distance
-		x := math.Sqrt(float64(d.R*d.R + d.B*d.B + d.G*d.G))
-		if x < score {
-			selected = i
-			score = x
-		}
+		// the euclidian distance
+		dist[i] = d.R*d.R + d.B*d.B + d.G*d.G
+		indices[i] = i
 	}
-	return selected
+	sort.SliceStable(indices, func(i, j int) bool {
+		return dist[indices[i]] < dist[indices[j]]
+	})
+	if len(indices) > k {
+		indices = indices[:k]
+	}
+	return indices
 }
 
-// ClosestColor returns the closest RGB color in the current palette
+// Select returns the palette color which best approximates the target.
 func (cp *ColorPalette) Select(target, current *image.RGBA, lines []shape.Scanline, alpha int) Color {
-	if len(cp.rgbColors) == 1 {
-		return cp.rgbColors[0]
+	candidates := []int{0}
+	if len(cp.rgbColors) > 1 {
+		best := cp.b.Select(target, current, lines, alpha)
+		candidates = cp.nearest(best, paletteCandidates)
+	}
+	var result Color
+	bestError := math.Inf(1)
+	for _, i := range candidates {
+		c := cp.rgbColors[i]
+		a, e := blendError(target, current, lines, c, alpha, cp.FreeAlpha)
+		if e < bestError {
+			result = Color{c.R, c.G, c.B, a}
+			bestError = e
+		}
 	}
-	best := cp.b.Select(target, current, lines, alpha)
-	i := cp.closestColorIdx(best)
-	return cp.rgbColors[i]
+	return result
+}
+
+// blendError returns the squared error against the target after drawing
+// the lines over current in the color c, which would be used at alpha. If
+// free is set, the alpha minimizing the error is returned instead.
+func blendError(target, current *image.RGBA, lines []shape.Scanline, c Color, alpha int, free bool) (int, float64) {
+	// With d = target - current and e = (c - current) * coverage, drawing
+	// at alpha a leaves the error sum((d - a*e)^2).
+	var dd, de, ee float64
+	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for j, v := range [3]int{c.R, c.G, c.B} {
+				cur := float64(current.Pix[i+j])
+				d := float64(target.Pix[i+j]) - cur
+				e := (float64(v) - cur) * m
+				dd += d * d
+				de += d * e
+				ee += e * e
+			}
+			i += 4
+		}
+	}
+	if free && ee > 0 {
+		alpha = clampInt(int(de/ee*255+0.5), 1, 255)
+	}
+	a := float64(alpha) / 255
+	return alpha, dd - 2*a*de + a*a*ee
 }
 
 // NewColorPalette returns a new color palette
@@ -160,6 +283,34 @@ func NewColorPalette(hexes []string) (cp *ColorPalette) {
 	return
 }
 
+// NewNamedColorPalette returns a new color palette from the entries of a
+// palette file, keeping their names.
+func NewNamedColorPalette(entries []PaletteEntry) (cp *ColorPalette) {
+	cp = new(ColorPalette)
+	for _, e := range entries {
+		cp.hexStrings = append(cp.hexStrings, e.Color.Hex())
+		cp.rgbColors = append(cp.rgbColors, e.Color)
+		cp.names = append(cp.names, e.Name)
+	}
+	return
+}
+
+// Colors returns the colors of the palette.
+func (cp *ColorPalette) Colors() []Color {
+	return cp.rgbColors
+}
+
+// ColorName returns the name of the palette entry with the same RGB as c.
+func (cp *ColorPalette) ColorName(c Color) string {
+	for i, name := range cp.names {
+		p := cp.rgbColors[i]
+		if p.R == c.R && p.G == c.G && p.B == c.B {
+			return name
+		}
+	}
+	return ""
+}
+
 func MakeColorPicker(config string) ColorPicker {
 	if config == "" {
 		return &BestColor{}
@@ -180,4 +331,3 @@ func MakeColorPicker(config string) ColorPicker {
 	}
 	return cp
 }
-
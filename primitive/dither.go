@@ -0,0 +1,170 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+)
+
+// DitherMode is how rendered output is reduced to the colors of a palette.
+type DitherMode int
+
+const (
+	DitherNone           DitherMode = iota
+	DitherFloydSteinberg            // error diffusion to four neighbors
+	DitherAtkinson                  // error diffusion of 3/4 of the error to six neighbors
+	DitherBayer                     // ordered dithering with an 8x8 threshold matrix
+)
+
+// ParseDitherMode parses the -dither flag.
+func ParseDitherMode(name string) (DitherMode, error) {
+	switch name {
+	case "", "none":
+		return DitherNone, nil
+	case "floyd":
+		return DitherFloydSteinberg, nil
+	case "atkinson":
+		return DitherAtkinson, nil
+	case "bayer":
+		return DitherBayer, nil
+	}
+	return DitherNone, fmt.Errorf("unrecognized dither mode: %s", name)
+}
+
+// diffusion is a neighbor receiving a share of the quantization error.
+type diffusion struct {
+	dx, dy int
+	weight float64
+}
+
+var diffusions = map[DitherMode][]diffusion{
+	DitherFloydSteinberg: {
+		{1, 0, 7.0 / 16}, {-1, 1, 3.0 / 16}, {0, 1, 5.0 / 16}, {1, 1, 1.0 / 16},
+	},
+	DitherAtkinson: {
+		{1, 0, 1.0 / 8}, {2, 0, 1.0 / 8}, {-1, 1, 1.0 / 8},
+		{0, 1, 1.0 / 8}, {1, 1, 1.0 / 8}, {0, 2, 1.0 / 8},
+	},
+}
+
+var bayer8 = [8][8]int{
+	{0, 32, 8, 40, 2, 34, 10, 42},
+	{48, 16, 56, 24, 50, 18, 58, 26},
+	{12, 44, 4, 36, 14, 46, 6, 38},
+	{60, 28, 52, 20, 62, 30, 54, 22},
+	{3, 35, 11, 43, 1, 33, 9, 41},
+	{51, 19, 59, 27, 49, 17, 57, 25},
+	{15, 47, 7, 39, 13, 45, 5, 37},
+	{63, 31, 55, 23, 61, 29, 53, 21},
+}
+
+// ditherPalette finds the nearest palette color to any color, caching the
+// answers since neighboring pixels tend to repeat.
+type ditherPalette struct {
+	colors []Color
+	cache  map[[3]int]int
+}
+
+func (p *ditherPalette) nearest(c [3]float64) Color {
+	key := [3]int{
+		clampInt(int(math.Round(c[0])), 0, 255),
+		clampInt(int(math.Round(c[1])), 0, 255),
+		clampInt(int(math.Round(c[2])), 0, 255),
+	}
+	if i, ok := p.cache[key]; ok {
+		return p.colors[i]
+	}
+	best, bestDist := 0, math.MaxInt32
+	for i, q := range p.colors {
+		dr, dg, db := key[0]-q.R, key[1]-q.G, key[2]-q.B
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	p.cache[key] = best
+	return p.colors[best]
+}
+
+// spread returns the mean distance from each palette color to its nearest
+// neighbor, which is how far ordered dithering pushes a color.
+func (p *ditherPalette) spread() float64 {
+	if len(p.colors) < 2 {
+		return 0
+	}
+	var total float64
+	for i, a := range p.colors {
+		nearest := math.Inf(1)
+		for j, b := range p.colors {
+			if i != j {
+				dr, dg, db := float64(a.R-b.R), float64(a.G-b.G), float64(a.B-b.B)
+				nearest = math.Min(nearest, math.Sqrt(dr*dr+dg*dg+db*db))
+			}
+		}
+		total += nearest
+	}
+	return total / float64(len(p.colors))
+}
+
+// Dither returns a copy of the image with every color replaced by one of
+// the palette colors, dithered with the mode so that areas between them
+// keep their shade. Partly transparent pixels keep their alpha.
+func Dither(im image.Image, palette []Color, mode DitherMode) *image.RGBA {
+	src := imageToRGBA(im)
+	dst := image.NewRGBA(src.Bounds())
+	if len(palette) == 0 {
+		copy(dst.Pix, src.Pix)
+		return dst
+	}
+	p := &ditherPalette{palette, make(map[[3]int]int)}
+	size := src.Bounds().Size()
+	w, h := size.X, size.Y
+	// unpremultiplied colors, with the error diffused into them
+	buf := make([][3]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(x, y)
+			if a := float64(src.Pix[i+3]); a > 0 {
+				for k := 0; k < 3; k++ {
+					buf[y*w+x][k] = float64(src.Pix[i+k]) * 255 / a
+				}
+			}
+		}
+	}
+	spread := p.spread()
+	neighbors := diffusions[mode]
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := src.PixOffset(x, y)
+			a := int(src.Pix[i+3])
+			if a == 0 {
+				continue
+			}
+			c := buf[y*w+x]
+			if mode == DitherBayer {
+				t := (float64(bayer8[y%8][x%8])+0.5)/64 - 0.5
+				for k := range c {
+					c[k] += t * spread
+				}
+			}
+			q := p.nearest(c)
+			dst.Pix[i] = uint8(q.R * a / 255)
+			dst.Pix[i+1] = uint8(q.G * a / 255)
+			dst.Pix[i+2] = uint8(q.B * a / 255)
+			dst.Pix[i+3] = uint8(a)
+			if mode == DitherBayer {
+				continue
+			}
+			e := [3]float64{c[0] - float64(q.R), c[1] - float64(q.G), c[2] - float64(q.B)}
+			for _, n := range neighbors {
+				nx, ny := x+n.dx, y+n.dy
+				if nx < 0 || nx >= w || ny >= h {
+					continue
+				}
+				for k := range e {
+					buf[ny*w+nx][k] += e[k] * n.weight
+				}
+			}
+		}
+	}
+	return dst
+}
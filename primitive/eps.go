@@ -0,0 +1,184 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"image"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// epsProlog defines the PDF path operators used by pdfPath, so that paths
+// are written the same way for both.
+const epsProlog = `/m {moveto} bind def
+/l {lineto} bind def
+/c {curveto} bind def
+/h {closepath} bind def
+/rg {setrgbcolor} bind def
+/w {setlinewidth} bind def
+/f {fill} bind def
+/S {stroke} bind def
+`
+
+// epsImage writes the image, opaque, covering the unit square.
+func epsImage(buf *bytes.Buffer, im image.Image) {
+	rgba := imageToRGBA(im)
+	size := rgba.Bounds().Size()
+	fmt.Fprintf(buf, "%d %d 8 [%d 0 0 %d 0 0] currentfile /ASCIIHexDecode filter false 3 colorimage\n",
+		size.X, size.Y, size.X, size.Y)
+	row := make([]byte, size.X*3)
+	for y := 0; y < size.Y; y++ {
+		for x := 0; x < size.X; x++ {
+			i := rgba.PixOffset(x, y)
+			copy(row[x*3:], rgba.Pix[i:i+3])
+		}
+		buf.WriteString(hex.EncodeToString(row))
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(">\n")
+}
+
+// epsShape writes the operators which paint the shape in the current
+// color. Rotated ellipses and rectangles are drawn unrotated in a rotated
+// coordinate system.
+func epsShape(buf *bytes.Buffer, s shape.Shape, path shape.Path) {
+	if c, ok := s.(*shape.Constrained); ok {
+		s = c.Shape
+	}
+	switch s := s.(type) {
+	case *shape.RotatedEllipse:
+		if s.Rx > 0 && s.Ry > 0 {
+			fmt.Fprintf(buf, "gsave %s translate %s rotate %s scale newpath 0 0 1 0 360 arc f grestore\n",
+				pdfNums(s.X, s.Y), pdfNum(s.Angle), pdfNums(s.Rx, s.Ry))
+			return
+		}
+	case *shape.RotatedRectangle:
+		fmt.Fprintf(buf, "gsave %d %d translate %d rotate %s %d %d rectfill grestore\n",
+			s.X, s.Y, s.Angle, pdfNums(-float64(s.Sx)/2, -float64(s.Sy)/2), s.Sx, s.Sy)
+		return
+	}
+	buf.WriteString("newpath\n")
+	pdfPath(buf, path)
+	if path.Width > 0 {
+		buf.WriteString("S\n")
+	} else {
+		buf.WriteString("f\n")
+	}
+}
+
+// EPS returns the model as an Encapsulated PostScript file with a bounding
+// box the size of the output, at a point per pixel. PostScript has no
+// transparency, so translucent shapes are flattened onto the mean color
+// of what is drawn under them and blend modes are drawn as normal; a transparent
+// background is taken to be white paper. Sprites, which have no outline,
+// and the mask of the opaque area are not drawn.
+func (model *Model) EPS() string {
+	sw, sh := model.Sw, model.Sh
+	var buf bytes.Buffer
+	buf.WriteString("%!PS-Adobe-3.0 EPSF-3.0\n")
+	fmt.Fprintf(&buf, "%%%%BoundingBox: 0 0 %d %d\n", sw, sh)
+	fmt.Fprintf(&buf, "%%%%HiResBoundingBox: 0 0 %d %d\n", sw, sh)
+	buf.WriteString("%%Creator: primitive\n%%LanguageLevel: 2\n%%Pages: 1\n%%EndComments\n")
+	buf.WriteString("%%BeginProlog\n" + epsProlog + "%%EndProlog\n")
+	buf.WriteString("%%Page: 1 1\ngsave\n")
+
+	// the output is drawn with y down
+	fmt.Fprintf(&buf, "0 %d translate 1 -1 scale\n", sh)
+
+	// the canvas as drawn so far, at plane resolution, for flattening the
+	// shapes onto what is under them
+	w, h := model.RC.W, model.RC.H
+	bg := model.Background
+	if bg.A == 0 {
+		bg = Color{255, 255, 255, 255}
+	}
+	under := uniformRGBA(image.Rect(0, 0, w, h), bg.NRGBA())
+	switch {
+	case model.initImage != nil:
+		fmt.Fprintf(&buf, "gsave %d %d scale\n", sw, sh)
+		epsImage(&buf, model.initImage)
+		buf.WriteString("grestore\n")
+		under = resizeRGBA(model.initImage, w, h)
+	case model.gradient != nil:
+		fmt.Fprintf(&buf, "gsave %d %d scale\n", sw, sh)
+		epsImage(&buf, model.gradient.image(sw, sh, model.Scale, 0.5))
+		buf.WriteString("grestore\n")
+		under = model.gradient.image(w, h, 1, 0)
+	case model.Background.A != 0:
+		fmt.Fprintf(&buf, "%s rg 0 0 %d %d rectfill\n", pdfColor(bg), sw, sh)
+	}
+
+	// the shapes, in plane units
+	fmt.Fprintf(&buf, "[%s] concat\n1 setlinecap 1 setlinejoin\n",
+		pdfNums(model.Scale, 0, 0, model.Scale, model.Scale/2, model.Scale/2))
+	skipped, flattened, blended := 0, 0, 0
+	for _, s := range model.Shapes {
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		c := s.Color
+		lines := model.rasterize(s.Shape)
+		if c.A < 255 {
+			flattened++
+			u := meanColor(under, lines, bg)
+			a := float64(c.A) / 255
+			c = Color{
+				int(float64(c.R)*a + float64(u.R)*(1-a) + 0.5),
+				int(float64(c.G)*a + float64(u.G)*(1-a) + 0.5),
+				int(float64(c.B)*a + float64(u.B)*(1-a) + 0.5),
+				255,
+			}
+		}
+		if s.Blend != BlendNormal {
+			blended++
+		}
+		fmt.Fprintf(&buf, "%s rg\n", pdfColor(c))
+		if path.Width > 0 {
+			fmt.Fprintf(&buf, "%s w\n", pdfNum(path.Width))
+		}
+		copies := model.copies(s.Shape)
+		for _, t := range copies {
+			if len(copies) > 1 {
+				fmt.Fprintf(&buf, "gsave [%s] concat\n", pdfNums(t[0], t[3], t[1], t[4], t[2], t[5]))
+			}
+			epsShape(&buf, s.Shape, path)
+			if len(copies) > 1 {
+				buf.WriteString("grestore\n")
+			}
+		}
+		drawLines(under, c, lines)
+	}
+	buf.WriteString("grestore\nshowpage\n%%EOF\n")
+	if skipped > 0 {
+		warn("eps: %d shapes without an outline were not drawn\n", skipped)
+	}
+	if flattened > 0 {
+		warn("eps: %d translucent shapes were flattened onto the shapes under them\n", flattened)
+	}
+	if blended > 0 {
+		warn("eps: %d shapes with a blend mode were drawn as normal\n", blended)
+	}
+	return buf.String()
+}
+
+// meanColor returns the mean color of the image over the scanlines, or the
+// fallback if they are empty.
+func meanColor(im *image.RGBA, lines []shape.Scanline, fallback Color) Color {
+	var r, g, b, n int
+	for _, line := range lines {
+		for x := line.X1; x <= line.X2; x++ {
+			i := im.PixOffset(x, line.Y)
+			r += int(im.Pix[i])
+			g += int(im.Pix[i+1])
+			b += int(im.Pix[i+2])
+			n++
+		}
+	}
+	if n == 0 {
+		return fallback
+	}
+	return Color{r / n, g / n, b / n, 255}
+}
@@ -0,0 +1,341 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// GradientMode is the kind of background gradient fitted to the target.
+type GradientMode int
+
+const (
+	GradientLinear GradientMode = iota // two colors along a line
+	GradientRadial                     // two colors from a center outward
+	GradientQuad                       // bilinear blend of four corner colors
+)
+
+// ParseGradientMode parses the -bg values which fit a gradient: "gradient",
+// "radial" or "quad".
+func ParseGradientMode(name string) (GradientMode, bool) {
+	switch name {
+	case "gradient":
+		return GradientLinear, true
+	case "radial":
+		return GradientRadial, true
+	case "quad":
+		return GradientQuad, true
+	}
+	return GradientLinear, false
+}
+
+// Gradient is a background which varies across the plane. Its coordinates
+// are in plane units, like those of the shapes.
+type Gradient struct {
+	Mode   GradientMode
+	X1, Y1 float64 // start of a linear gradient, center of a radial one or top left of a quad
+	X2, Y2 float64 // end of a linear gradient or bottom right of a quad
+	R      float64 // radius of a radial gradient
+	Colors []Color // the two stops, or the top left, top right, bottom left and bottom right corners
+}
+
+// FitGradient returns the gradient of the mode which best fits the target,
+// by least squares.
+func FitGradient(target *image.RGBA, mode GradientMode) *Gradient {
+	size := target.Bounds().Size()
+	w, h := size.X, size.Y
+	switch mode {
+	case GradientRadial:
+		return fitRadial(target, w, h)
+	case GradientQuad:
+		return fitQuad(target, w, h)
+	}
+	return fitLinear(target, w, h)
+}
+
+// forPixels calls f with the center of each pixel of the target, in plane
+// units, and its color.
+func forPixels(target *image.RGBA, f func(x, y float64, c [3]float64)) {
+	size := target.Bounds().Size()
+	for y := 0; y < size.Y; y++ {
+		i := target.PixOffset(0, y)
+		for x := 0; x < size.X; x++ {
+			p := target.Pix[i : i+3]
+			f(float64(x)+0.5, float64(y)+0.5, [3]float64{float64(p[0]), float64(p[1]), float64(p[2])})
+			i += 4
+		}
+	}
+}
+
+// fitStops fits c = a + b*t to each channel, for t in [0, 1] given by
+// param, and returns the colors at t = 0 and t = 1 along with the squared
+// error of the fit.
+func fitStops(target *image.RGBA, param func(x, y float64) float64) ([]Color, float64) {
+	var n, st, stt float64
+	var sc, sct, scc [3]float64
+	forPixels(target, func(x, y float64, c [3]float64) {
+		t := param(x, y)
+		n++
+		st += t
+		stt += t * t
+		for k := range c {
+			sc[k] += c[k]
+			sct[k] += c[k] * t
+			scc[k] += c[k] * c[k]
+		}
+	})
+	var c0, c1 [3]int
+	var e float64
+	for k := range sc {
+		a, b := sc[k]/n, 0.0
+		if den := n*stt - st*st; den > 1e-9 {
+			b = (n*sct[k] - st*sc[k]) / den
+			a = (sc[k] - b*st) / n
+		}
+		e += scc[k] - 2*a*sc[k] - 2*b*sct[k] + a*a*n + 2*a*b*st + b*b*stt
+		c0[k] = clampInt(int(math.Round(a)), 0, 255)
+		c1[k] = clampInt(int(math.Round(a+b)), 0, 255)
+	}
+	return []Color{{c0[0], c0[1], c0[2], 255}, {c1[0], c1[1], c1[2], 255}}, e
+}
+
+func fitLinear(target *image.RGBA, w, h int) *Gradient {
+	// fit a plane to each channel, then run the gradient along the
+	// direction in which the planes change the most
+	m, v := newMatrix(3, 3), newMatrix(3, 3)
+	forPixels(target, func(x, y float64, c [3]float64) {
+		p := [3]float64{1, x, y}
+		for i := range p {
+			for j := range p {
+				m[i][j] += p[i] * p[j]
+			}
+			for k := range c {
+				v[k][i] += p[i] * c[k]
+			}
+		}
+	})
+	var gxx, gxy, gyy float64
+	for k := range v {
+		if s := solveLinear(m, v[k]); s != nil {
+			gxx += s[1] * s[1]
+			gxy += s[1] * s[2]
+			gyy += s[2] * s[2]
+		}
+	}
+	ux, uy := 0.0, 1.0
+	if gxx+gyy > 1e-12 {
+		theta := math.Atan2(2*gxy, gxx-gyy) / 2
+		ux, uy = math.Cos(theta), math.Sin(theta)
+	}
+	// the extent of the plane along the direction
+	fw, fh := float64(w), float64(h)
+	s0, s1 := math.Inf(1), math.Inf(-1)
+	for _, p := range [4][2]float64{{0, 0}, {fw, 0}, {0, fh}, {fw, fh}} {
+		s := p[0]*ux + p[1]*uy
+		s0, s1 = math.Min(s0, s), math.Max(s1, s)
+	}
+	colors, _ := fitStops(target, func(x, y float64) float64 {
+		return (x*ux + y*uy - s0) / (s1 - s0)
+	})
+	cx, cy := fw/2, fh/2
+	c := cx*ux + cy*uy
+	return &Gradient{
+		Mode:   GradientLinear,
+		X1:     cx + ux*(s0-c),
+		Y1:     cy + uy*(s0-c),
+		X2:     cx + ux*(s1-c),
+		Y2:     cy + uy*(s1-c),
+		Colors: colors,
+	}
+}
+
+func fitRadial(target *image.RGBA, w, h int) *Gradient {
+	// try centers on a grid over the plane, keeping the best fit
+	const n = 4
+	fw, fh := float64(w), float64(h)
+	var best *Gradient
+	bestError := math.Inf(1)
+	for j := 0; j <= n; j++ {
+		for i := 0; i <= n; i++ {
+			cx, cy := fw*float64(i)/n, fh*float64(j)/n
+			r := math.Max(math.Hypot(math.Max(cx, fw-cx), math.Max(cy, fh-cy)), 1)
+			colors, e := fitStops(target, func(x, y float64) float64 {
+				return math.Hypot(x-cx, y-cy) / r
+			})
+			if e < bestError {
+				bestError = e
+				best = &Gradient{Mode: GradientRadial, X1: cx, Y1: cy, R: r, Colors: colors}
+			}
+		}
+	}
+	return best
+}
+
+func fitQuad(target *image.RGBA, w, h int) *Gradient {
+	fw, fh := float64(w), float64(h)
+	m, v := newMatrix(4, 4), newMatrix(3, 4)
+	forPixels(target, func(x, y float64, c [3]float64) {
+		u, t := x/fw, y/fh
+		p := [4]float64{(1 - u) * (1 - t), u * (1 - t), (1 - u) * t, u * t}
+		for i := range p {
+			for j := range p {
+				m[i][j] += p[i] * p[j]
+			}
+			for k := range c {
+				v[k][i] += p[i] * c[k]
+			}
+		}
+	})
+	var corners [4][3]int
+	for k := range v {
+		if s := solveLinear(m, v[k]); s != nil {
+			for i := range corners {
+				corners[i][k] = clampInt(int(math.Round(s[i])), 0, 255)
+			}
+		}
+	}
+	colors := make([]Color, 4)
+	for i, c := range corners {
+		colors[i] = Color{c[0], c[1], c[2], 255}
+	}
+	return &Gradient{Mode: GradientQuad, X2: fw, Y2: fh, Colors: colors}
+}
+
+func newMatrix(rows, cols int) [][]float64 {
+	m := make([][]float64, rows)
+	for i := range m {
+		m[i] = make([]float64, cols)
+	}
+	return m
+}
+
+// solveLinear solves m x = v by Gaussian elimination with partial
+// pivoting, returning nil if m is singular. m and v are not modified.
+func solveLinear(m [][]float64, v []float64) []float64 {
+	n := len(v)
+	a := make([][]float64, n)
+	for i := range a {
+		a[i] = make([]float64, n+1)
+		for j := 0; j < n; j++ {
+			a[i][j] = m[i][j]
+		}
+		a[i][n] = v[i]
+	}
+	for col := 0; col < n; col++ {
+		pivot := col
+		for row := col + 1; row < n; row++ {
+			if math.Abs(a[row][col]) > math.Abs(a[pivot][col]) {
+				pivot = row
+			}
+		}
+		if math.Abs(a[pivot][col]) < 1e-12 {
+			return nil
+		}
+		a[col], a[pivot] = a[pivot], a[col]
+		for row := col + 1; row < n; row++ {
+			f := a[row][col] / a[col][col]
+			for j := col; j <= n; j++ {
+				a[row][j] -= f * a[col][j]
+			}
+		}
+	}
+	x := make([]float64, n)
+	for i := n - 1; i >= 0; i-- {
+		s := a[i][n]
+		for j := i + 1; j < n; j++ {
+			s -= a[i][j] * x[j]
+		}
+		x[i] = s / a[i][i]
+	}
+	return x
+}
+
+// at returns the color of the gradient at the point, in plane units.
+func (g *Gradient) at(x, y float64) Color {
+	lerp := func(a, b Color, t float64) [3]float64 {
+		return [3]float64{
+			float64(a.R) + (float64(b.R)-float64(a.R))*t,
+			float64(a.G) + (float64(b.G)-float64(a.G))*t,
+			float64(a.B) + (float64(b.B)-float64(a.B))*t,
+		}
+	}
+	var c [3]float64
+	switch g.Mode {
+	case GradientLinear:
+		dx, dy := g.X2-g.X1, g.Y2-g.Y1
+		t := 0.0
+		if d := dx*dx + dy*dy; d > 0 {
+			t = clamp(((x-g.X1)*dx+(y-g.Y1)*dy)/d, 0, 1)
+		}
+		c = lerp(g.Colors[0], g.Colors[1], t)
+	case GradientRadial:
+		t := clamp(math.Hypot(x-g.X1, y-g.Y1)/g.R, 0, 1)
+		c = lerp(g.Colors[0], g.Colors[1], t)
+	case GradientQuad:
+		u := clamp((x-g.X1)/(g.X2-g.X1), 0, 1)
+		t := clamp((y-g.Y1)/(g.Y2-g.Y1), 0, 1)
+		top := lerp(g.Colors[0], g.Colors[1], u)
+		bottom := lerp(g.Colors[2], g.Colors[3], u)
+		for k := range c {
+			c[k] = top[k] + (bottom[k]-top[k])*t
+		}
+	}
+	return Color{int(math.Round(c[0])), int(math.Round(c[1])), int(math.Round(c[2])), 255}
+}
+
+// image renders the gradient into a w by h image, where pixel x, y is
+// centered on the plane point ((x+0.5)/scale-offset, (y+0.5)/scale-offset).
+func (g *Gradient) image(w, h int, scale, offset float64) *image.RGBA {
+	im := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		py := (float64(y)+0.5)/scale - offset
+		for x := 0; x < w; x++ {
+			c := g.at((float64(x)+0.5)/scale-offset, py)
+			i := im.PixOffset(x, y)
+			im.Pix[i] = uint8(c.R)
+			im.Pix[i+1] = uint8(c.G)
+			im.Pix[i+2] = uint8(c.B)
+			im.Pix[i+3] = 255
+		}
+	}
+	return im
+}
+
+// svg returns the definitions and the elements which draw the gradient on
+// a w by h canvas, where plane point p is drawn at (p+0.5)*scale.
+func (g *Gradient) svg(w, h int, scale float64) ([]string, []string) {
+	out := func(v float64) string {
+		return shape.SVGNum((v + 0.5) * scale)
+	}
+	stops := func(a, b Color) string {
+		return fmt.Sprintf("<stop offset=\"0\" stop-color=\"%s\" /><stop offset=\"1\" stop-color=\"%s\" />", a.Hex(), b.Hex())
+	}
+	rect := func(attrs string) string {
+		return fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" %s />", w, h, attrs)
+	}
+	switch g.Mode {
+	case GradientRadial:
+		return []string{fmt.Sprintf(
+				"<radialGradient id=\"bg\" gradientUnits=\"userSpaceOnUse\" cx=\"%s\" cy=\"%s\" r=\"%s\">%s</radialGradient>",
+				out(g.X1), out(g.Y1), shape.SVGNum(g.R*scale), stops(g.Colors[0], g.Colors[1]))},
+			[]string{rect("fill=\"url(#bg)\"")}
+	case GradientQuad:
+		// the bottom edge fades in over the top edge
+		x1, y1, x2, y2 := out(g.X1), out(g.Y1), out(g.X2), out(g.Y2)
+		horizontal := "gradientUnits=\"userSpaceOnUse\" x1=\"%s\" y1=\"0\" x2=\"%s\" y2=\"0\""
+		return []string{
+				fmt.Sprintf("<linearGradient id=\"bg-top\" "+horizontal+">%s</linearGradient>", x1, x2, stops(g.Colors[0], g.Colors[1])),
+				fmt.Sprintf("<linearGradient id=\"bg-bottom\" "+horizontal+">%s</linearGradient>", x1, x2, stops(g.Colors[2], g.Colors[3])),
+				fmt.Sprintf("<linearGradient id=\"bg-fade\" gradientUnits=\"userSpaceOnUse\" x1=\"0\" y1=\"%s\" x2=\"0\" y2=\"%s\">"+
+					"<stop offset=\"0\" stop-color=\"#fff\" stop-opacity=\"0\" /><stop offset=\"1\" stop-color=\"#fff\" stop-opacity=\"1\" /></linearGradient>", y1, y2),
+				"<mask id=\"bg-mask\" style=\"mask-type:alpha\">" + rect("fill=\"url(#bg-fade)\"") + "</mask>",
+			},
+			[]string{rect("fill=\"url(#bg-top)\""), rect("fill=\"url(#bg-bottom)\" mask=\"url(#bg-mask)\"")}
+	}
+	return []string{fmt.Sprintf(
+			"<linearGradient id=\"bg\" gradientUnits=\"userSpaceOnUse\" x1=\"%s\" y1=\"%s\" x2=\"%s\" y2=\"%s\">%s</linearGradient>",
+			out(g.X1), out(g.Y1), out(g.X2), out(g.Y2), stops(g.Colors[0], g.Colors[1]))},
+		[]string{rect("fill=\"url(#bg)\"")}
+}
@@ -0,0 +1,375 @@
+package primitive
+
+import (
+	"fmt"
+	"html"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// layerLabels returns, for each pixel of the output, the index of the
+// layer of the topmost shape covering it, or of the background, or -1 if
+// nothing opaque covers it. Shape colors are quantized to the nearest
+// layer color and drawn as if opaque.
+func (model *Model) layerLabels(layers *ColorPalette) []int {
+	sw, sh := model.Sw, model.Sh
+	labels := make([]int, sw*sh)
+	background := imageToRGBA(model.newContext().Image())
+	for y := 0; y < sh; y++ {
+		for x := 0; x < sw; x++ {
+			i := background.PixOffset(x, y)
+			p := background.Pix[i : i+4]
+			if p[3] < 128 {
+				labels[y*sw+x] = -1
+			} else {
+				labels[y*sw+x] = layers.nearest(Color{int(p[0]), int(p[1]), int(p[2]), 255}, 1)[0]
+			}
+		}
+	}
+
+	skipped := 0
+	for _, s := range model.Shapes {
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		label := layers.nearest(s.Color, 1)[0]
+		lines, closed := flattenPath(path)
+		for _, t := range model.copies(s.Shape) {
+			var outlines [][]plotPoint
+			for i, line := range lines {
+				out := make([]plotPoint, len(line))
+				for j, q := range line {
+					x, y := t.apply(q.X, q.Y)
+					out[j] = plotPoint{(x + 0.5) * model.Scale, (y + 0.5) * model.Scale}
+				}
+				if path.Width > 0 {
+					fillStroke(labels, sw, sh, out, path.Width*model.Scale/2, label)
+				} else if closed[i] {
+					outlines = append(outlines, out)
+				}
+			}
+			fillPolygons(labels, sw, sh, outlines, label)
+		}
+	}
+	if skipped > 0 {
+		warn("layers: %d shapes without an outline were not drawn\n", skipped)
+	}
+
+	if model.outputMask != nil {
+		for i, a := range model.outputMask.Pix {
+			if a < 128 {
+				labels[i] = -1
+			}
+		}
+	}
+	return labels
+}
+
+// fillPolygons sets the label of the pixels whose centers are inside the
+// closed polylines, by the nonzero rule of the paths of the shapes.
+func fillPolygons(labels []int, w, h int, lines [][]plotPoint, label int) {
+	if len(lines) == 0 {
+		return
+	}
+	y0, y1 := math.Inf(1), math.Inf(-1)
+	for _, line := range lines {
+		for _, p := range line {
+			y0, y1 = math.Min(y0, p.Y), math.Max(y1, p.Y)
+		}
+	}
+	// a crossing of the scanline, with the direction of the edge
+	type crossing struct {
+		X       float64
+		Winding int
+	}
+	for y := clampInt(int(y0), 0, h); y < clampInt(int(y1)+1, 0, h); y++ {
+		cy := float64(y) + 0.5
+		var xs []crossing
+		for _, line := range lines {
+			for i := 1; i < len(line); i++ {
+				a, b := line[i-1], line[i]
+				if (a.Y <= cy) != (b.Y <= cy) {
+					winding := 1
+					if b.Y < a.Y {
+						winding = -1
+					}
+					xs = append(xs, crossing{a.X + (cy-a.Y)*(b.X-a.X)/(b.Y-a.Y), winding})
+				}
+			}
+		}
+		sort.Slice(xs, func(i, j int) bool { return xs[i].X < xs[j].X })
+		winding := 0
+		for i := 0; i+1 < len(xs); i++ {
+			winding += xs[i].Winding
+			if winding == 0 {
+				continue
+			}
+			x1 := clampInt(int(math.Ceil(xs[i].X-0.5)), 0, w)
+			x2 := clampInt(int(math.Ceil(xs[i+1].X-0.5)), 0, w)
+			for x := x1; x < x2; x++ {
+				labels[y*w+x] = label
+			}
+		}
+	}
+}
+
+// fillStroke sets the label of the pixels whose centers are within r of
+// the polyline.
+func fillStroke(labels []int, w, h int, line []plotPoint, r float64, label int) {
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		dx, dy := b.X-a.X, b.Y-a.Y
+		d := dx*dx + dy*dy
+		x1 := clampInt(int(math.Min(a.X, b.X)-r), 0, w-1)
+		x2 := clampInt(int(math.Max(a.X, b.X)+r), 0, w-1)
+		y1 := clampInt(int(math.Min(a.Y, b.Y)-r), 0, h-1)
+		y2 := clampInt(int(math.Max(a.Y, b.Y)+r), 0, h-1)
+		for y := y1; y <= y2; y++ {
+			for x := x1; x <= x2; x++ {
+				px, py := float64(x)+0.5-a.X, float64(y)+0.5-a.Y
+				t := 0.0
+				if d > 0 {
+					t = clamp((px*dx+py*dy)/d, 0, 1)
+				}
+				ex, ey := px-t*dx, py-t*dy
+				if ex*ex+ey*ey <= r*r {
+					labels[y*w+x] = label
+				}
+			}
+		}
+	}
+}
+
+// layerJunctions returns, for each pixel corner, whether the outlines of
+// the layers meet there: three or more labels around it, counting the
+// outside of the output as a label, two labels which touch only at their
+// corners, or a corner of the output. The outlines are smoothed between
+// junctions, which stay where they are.
+func layerJunctions(labels []int, w, h int) []bool {
+	at := func(x, y int) int {
+		if x < 0 || y < 0 || x >= w || y >= h {
+			return -2
+		}
+		return labels[y*w+x]
+	}
+	junctions := make([]bool, (w+1)*(h+1))
+	for y := 0; y <= h; y++ {
+		for x := 0; x <= w; x++ {
+			a, b, c, d := at(x-1, y-1), at(x, y-1), at(x-1, y), at(x, y)
+			distinct := 1
+			if b != a {
+				distinct++
+			}
+			if c != a && c != b {
+				distinct++
+			}
+			if d != a && d != b && d != c {
+				distinct++
+			}
+			saddle := a == d && b == c && a != b
+			corner := (x == 0 || x == w) && (y == 0 || y == h)
+			junctions[y*(w+1)+x] = distinct >= 3 || saddle || corner
+		}
+	}
+	return junctions
+}
+
+// traceRegion returns the outlines of the pixels with the label as closed
+// loops of pixel corners. Outer edges run clockwise and holes
+// counterclockwise, so the loops fill the region by either fill rule.
+// The loops are smoothed between the junctions.
+func traceRegion(labels []int, w, h, label int, junctions []bool) [][][2]int {
+	inside := func(x, y int) bool {
+		return x >= 0 && y >= 0 && x < w && y < h && labels[y*w+x] == label
+	}
+	// the directions right, down, left and up
+	dirs := [4][2]int{{1, 0}, {0, 1}, {-1, 0}, {0, -1}}
+	// edges[(y*(w+1)+x)*4+d] is the edge leaving corner x, y in direction
+	// d, with the region on its right
+	edges := make([]bool, (w+1)*(h+1)*4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			if !inside(x, y) {
+				continue
+			}
+			if !inside(x, y-1) {
+				edges[(y*(w+1)+x)*4+0] = true
+			}
+			if !inside(x+1, y) {
+				edges[(y*(w+1)+x+1)*4+1] = true
+			}
+			if !inside(x, y+1) {
+				edges[((y+1)*(w+1)+x+1)*4+2] = true
+			}
+			if !inside(x-1, y) {
+				edges[((y+1)*(w+1)+x)*4+3] = true
+			}
+		}
+	}
+	var loops [][][2]int
+	for start := range edges {
+		if !edges[start] {
+			continue
+		}
+		var loop [][2]int
+		e := start
+		for edges[e] {
+			edges[e] = false
+			v, d := e/4, e%4
+			x, y := v%(w+1), v/(w+1)
+			loop = append(loop, [2]int{x, y})
+			x, y = x+dirs[d][0], y+dirs[d][1]
+			v = y*(w+1) + x
+			// turn right where two pixels of the region meet at a corner
+			for _, turn := range [3]int{1, 0, 3} {
+				if next := v*4 + (d+turn)%4; edges[next] {
+					e = next
+					break
+				}
+			}
+		}
+		if loop = smoothLoop(loop, junctions, w); len(loop) >= 3 {
+			loops = append(loops, loop)
+		}
+	}
+	return loops
+}
+
+// smoothLoop simplifies the loop of pixel corners between its junctions.
+// A loop without junctions starts from its least corner, so that it is
+// split the same way whichever of its sides it was traced from.
+func smoothLoop(loop [][2]int, junctions []bool, w int) [][2]int {
+	n := len(loop)
+	first := -1
+	for i, p := range loop {
+		if junctions[p[1]*(w+1)+p[0]] {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		first = 0
+		for i, p := range loop {
+			if cornerLess(p, loop[first]) {
+				first = i
+			}
+		}
+	}
+	var result [][2]int
+	chain := [][2]int{loop[first]}
+	for k := 1; k <= n; k++ {
+		p := loop[(first+k)%n]
+		chain = append(chain, p)
+		if k == n || junctions[p[1]*(w+1)+p[0]] {
+			smooth := smoothChain(chain)
+			result = append(result, smooth[:len(smooth)-1]...)
+			chain = [][2]int{p}
+		}
+	}
+	return result
+}
+
+// smoothChain simplifies the chain of pixel corners in the direction which
+// starts from its lesser end, so that the layers on either side of it,
+// which trace it in opposite directions, share the same outline.
+func smoothChain(chain [][2]int) [][2]int {
+	n := len(chain)
+	a, b := chain[0], chain[n-1]
+	if a == b && n > 2 {
+		a, b = chain[1], chain[n-2]
+	}
+	if !cornerLess(b, a) {
+		return simplifyChain(chain, 1)
+	}
+	reversed := make([][2]int, n)
+	for i, p := range chain {
+		reversed[n-1-i] = p
+	}
+	simple := simplifyChain(reversed, 1)
+	for i, j := 0, len(simple)-1; i < j; i, j = i+1, j-1 {
+		simple[i], simple[j] = simple[j], simple[i]
+	}
+	return simple
+}
+
+// simplifyChain keeps the ends of the chain and the corners which are
+// farther than tolerance from the chord of the corners kept around them,
+// by the Douglas-Peucker algorithm.
+func simplifyChain(chain [][2]int, tolerance float64) [][2]int {
+	n := len(chain)
+	if n <= 2 {
+		return append([][2]int(nil), chain...)
+	}
+	a, b := chain[0], chain[n-1]
+	dx, dy := float64(b[0]-a[0]), float64(b[1]-a[1])
+	d := math.Hypot(dx, dy)
+	far, index := -1.0, 0
+	for i := 1; i < n-1; i++ {
+		px, py := float64(chain[i][0]-a[0]), float64(chain[i][1]-a[1])
+		e := math.Hypot(px, py)
+		if d > 0 {
+			e = math.Abs(px*dy-py*dx) / d
+		}
+		if e > far {
+			far, index = e, i
+		}
+	}
+	if far <= tolerance {
+		return [][2]int{a, b}
+	}
+	left := simplifyChain(chain[:index+1], tolerance)
+	right := simplifyChain(chain[index:], tolerance)
+	return append(left[:len(left)-1], right...)
+}
+
+// cornerLess orders pixel corners by row and then by column.
+func cornerLess(a, b [2]int) bool {
+	return a[1] < b[1] || a[1] == b[1] && a[0] < b[0]
+}
+
+// LayeredSVG returns the model as an SVG for cutting paper or vinyl
+// layers. Shape colors are quantized to the layer colors and drawn as if
+// opaque; each layer is a group with a path of closed loops around the
+// area where it is visible, so that no two layers overlap. The outlines
+// are simplified from the pixels of the output, and layers which touch
+// share the same outline between them. Sprites, which
+// have no outline, are not drawn.
+func (model *Model) LayeredSVG(layers *ColorPalette) string {
+	sw, sh := model.Sw, model.Sh
+	labels := model.layerLabels(layers)
+	junctions := layerJunctions(labels, sw, sh)
+	var lines []string
+	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"%d\" height=\"%d\" viewBox=\"0 0 %d %d\">", sw, sh, sw, sh))
+	for i, c := range layers.Colors() {
+		loops := traceRegion(labels, sw, sh, i, junctions)
+		if len(loops) == 0 {
+			continue
+		}
+		attrs := fmt.Sprintf("id=\"layer%d\" fill=\"%s\"", i+1, c.Hex())
+		if name := layers.ColorName(c); name != "" {
+			attrs += fmt.Sprintf(" data-color-name=\"%s\"", html.EscapeString(name))
+		}
+		lines = append(lines, fmt.Sprintf("<g %s>", attrs))
+		// the holes are part of the path of the area around them
+		var d []string
+		for _, loop := range loops {
+			for j, p := range loop {
+				if j == 0 {
+					d = append(d, fmt.Sprintf("M%d %d", p[0], p[1]))
+				} else {
+					d = append(d, fmt.Sprintf("L%d %d", p[0], p[1]))
+				}
+			}
+			d = append(d, "Z")
+		}
+		lines = append(lines, fmt.Sprintf("<path d=\"%s\" />", strings.Join(d, " ")))
+		lines = append(lines, "</g>")
+	}
+	lines = append(lines, "</svg>")
+	return strings.Join(lines, "\n")
+}
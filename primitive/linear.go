@@ -0,0 +1,228 @@
+package primitive
+
+import (
+	"image"
+	"math"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// With Model.Linear set, shapes are composited and their colors solved in
+// linear light rather than on gamma-encoded sRGB values, so overlapping
+// translucent shapes mix as light does. The model keeps 16-bit linear
+// copies of the target and current images; the sRGB images are still used
+// to score shapes and to write the output.
+
+var (
+	srgbToLinear16 [256]uint16
+	linear16ToSrgb [65536]uint8
+)
+
+func init() {
+	for i := range srgbToLinear16 {
+		srgbToLinear16[i] = uint16(math.Round(srgbToLinear(float64(i)/255) * 65535))
+	}
+	for i := range linear16ToSrgb {
+		linear16ToSrgb[i] = uint8(math.Round(linearToSrgb(float64(i)/65535) * 255))
+	}
+}
+
+// linearRGBA is an image with 16-bit linear-light channels, laid out like
+// image.RGBA. Alpha is not gamma encoded, so it is stored as is.
+type linearRGBA struct {
+	Pix    []uint16
+	Stride int
+	Rect   image.Rectangle
+}
+
+func newLinearRGBA(src *image.RGBA) *linearRGBA {
+	im := &linearRGBA{make([]uint16, len(src.Pix)), src.Stride, src.Rect}
+	for i := 0; i < len(src.Pix); i += 4 {
+		im.Pix[i] = srgbToLinear16[src.Pix[i]]
+		im.Pix[i+1] = srgbToLinear16[src.Pix[i+1]]
+		im.Pix[i+2] = srgbToLinear16[src.Pix[i+2]]
+		im.Pix[i+3] = uint16(src.Pix[i+3]) * 0x101
+	}
+	return im
+}
+
+func (im *linearRGBA) PixOffset(x, y int) int {
+	return (y-im.Rect.Min.Y)*im.Stride + (x-im.Rect.Min.X)*4
+}
+
+// linearColor returns the channels of c in linear light.
+func linearColor(c Color) [3]int {
+	return [3]int{int(srgbToLinear16[c.R]), int(srgbToLinear16[c.G]), int(srgbToLinear16[c.B])}
+}
+
+func copyLinesLinear(dst, src *linearRGBA, lines []shape.Scanline) {
+	for _, line := range lines {
+		a := dst.PixOffset(line.X1, line.Y)
+		b := a + (line.X2-line.X1+1)*4
+		copy(dst.Pix[a:b], src.Pix[a:b])
+	}
+}
+
+// drawLinesLinear is drawLines in linear light. It draws onto lin, and
+// writes the sRGB encoding of the pixels it changes into im.
+func drawLinesLinear(im *image.RGBA, lin *linearRGBA, c Color, lines []shape.Scanline) {
+	const m = 0xffff
+	s := linearColor(c)
+	sa := uint32(c.A) * 0x101
+	for _, line := range lines {
+		a := int(sa * line.Alpha / m)
+		i := lin.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k := 0; k < 3; k++ {
+				d := int(lin.Pix[i+k])
+				v := d + ((s[k]-d)*a+m/2)/m
+				lin.Pix[i+k] = uint16(v)
+				im.Pix[i+k] = linear16ToSrgb[v]
+			}
+			da := int(lin.Pix[i+3])
+			da += (m - da) * a / m
+			lin.Pix[i+3] = uint16(da)
+			im.Pix[i+3] = uint8(da >> 8)
+			i += 4
+		}
+	}
+}
+
+// compositeLinear composites the color c onto lin in linear light, using
+// the alpha of layer, which is the shape drawn in c, as the coverage, and
+// writes the sRGB encoding of the pixels it changes into dst.
+func compositeLinear(dst *image.RGBA, lin *linearRGBA, layer *image.RGBA, c Color) {
+	const m = 0xffff
+	s := linearColor(c)
+	for i := 0; i < len(dst.Pix); i += 4 {
+		a := int(layer.Pix[i+3]) * 0x101
+		if a == 0 {
+			continue
+		}
+		for k := 0; k < 3; k++ {
+			d := int(lin.Pix[i+k])
+			v := d + ((s[k]-d)*a+m/2)/m
+			lin.Pix[i+k] = uint16(v)
+			dst.Pix[i+k] = linear16ToSrgb[v]
+		}
+		da := int(lin.Pix[i+3])
+		da += (m - da) * a / m
+		lin.Pix[i+3] = uint16(da)
+		dst.Pix[i+3] = uint8(da >> 8)
+	}
+}
+
+// encodeColor returns the sRGB color for linear channels in [0, 65535].
+func encodeColor(alpha int, c [3]float64) Color {
+	r := linear16ToSrgb[clampInt(int(c[0]+0.5), 0, 65535)]
+	g := linear16ToSrgb[clampInt(int(c[1]+0.5), 0, 65535)]
+	b := linear16ToSrgb[clampInt(int(c[2]+0.5), 0, 65535)]
+	return Color{int(r), int(g), int(b), alpha}
+}
+
+// LinearPicker is implemented by color pickers which can choose colors in
+// linear light. The color returned is still sRGB encoded.
+type LinearPicker interface {
+	SelectLinear(target, current *linearRGBA, lines []shape.Scanline, alpha int) Color
+}
+
+// selectColorLinear picks the color for the lines with the picker, in
+// linear light when the picker supports it.
+func selectColorLinear(picker ColorPicker, target, current *image.RGBA, targetLinear, currentLinear *linearRGBA, lines []shape.Scanline, alpha int) Color {
+	if p, ok := picker.(LinearPicker); ok {
+		return p.SelectLinear(targetLinear, currentLinear, lines, alpha)
+	}
+	return picker.Select(target, current, lines, alpha)
+}
+
+func (s *BestColor) SelectLinear(target, current *linearRGBA, lines []shape.Scanline, alpha int) Color {
+	var sum [3]float64
+	var count float64
+	a := 255 / float64(alpha)
+	for _, line := range lines {
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k := 0; k < 3; k++ {
+				t, c := float64(target.Pix[i+k]), float64(current.Pix[i+k])
+				sum[k] += (t-c)*a + c
+			}
+			count++
+			i += 4
+		}
+	}
+	if count == 0 {
+		return Color{}
+	}
+	return encodeColor(alpha, [3]float64{sum[0] / count, sum[1] / count, sum[2] / count})
+}
+
+func (s *BestGreyscale) SelectLinear(target, current *linearRGBA, lines []shape.Scanline, alpha int) Color {
+	c := (&BestColor{}).SelectLinear(target, current, lines, alpha)
+	// average the channels in linear light
+	l := linearColor(c)
+	bw := int(linear16ToSrgb[(l[0]+l[1]+l[2])/3])
+	return Color{bw, bw, bw, alpha}
+}
+
+func (s *BestAlpha) SelectLinear(target, current *linearRGBA, lines []shape.Scanline, alpha int) Color {
+	var sums alphaSums
+	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			sums.add(m,
+				[3]float64{float64(target.Pix[i]), float64(target.Pix[i+1]), float64(target.Pix[i+2])},
+				[3]float64{float64(current.Pix[i]), float64(current.Pix[i+1]), float64(current.Pix[i+2])})
+			i += 4
+		}
+	}
+	if sums.mm == 0 {
+		return Color{}
+	}
+	return encodeColor(sums.solve(65535))
+}
+
+func (cp *ColorPalette) SelectLinear(target, current *linearRGBA, lines []shape.Scanline, alpha int) Color {
+	candidates := []int{0}
+	if len(cp.rgbColors) > 1 {
+		best := cp.b.SelectLinear(target, current, lines, alpha)
+		candidates = cp.nearest(best, paletteCandidates)
+	}
+	var result Color
+	bestError := math.Inf(1)
+	for _, i := range candidates {
+		c := cp.rgbColors[i]
+		a, e := blendErrorLinear(target, current, lines, c, alpha, cp.FreeAlpha)
+		if e < bestError {
+			result = Color{c.R, c.G, c.B, a}
+			bestError = e
+		}
+	}
+	return result
+}
+
+// blendErrorLinear is blendError in linear light.
+func blendErrorLinear(target, current *linearRGBA, lines []shape.Scanline, c Color, alpha int, free bool) (int, float64) {
+	var dd, de, ee float64
+	s := linearColor(c)
+	for _, line := range lines {
+		m := float64(line.Alpha) / 0xffff
+		i := target.PixOffset(line.X1, line.Y)
+		for x := line.X1; x <= line.X2; x++ {
+			for k := 0; k < 3; k++ {
+				cur := float64(current.Pix[i+k])
+				d := float64(target.Pix[i+k]) - cur
+				e := (float64(s[k]) - cur) * m
+				dd += d * d
+				de += d * e
+				ee += e * e
+			}
+			i += 4
+		}
+	}
+	if free && ee > 0 {
+		alpha = clampInt(int(de/ee*255+0.5), 1, 255)
+	}
+	a := float64(alpha) / 255
+	return alpha, dd - 2*a*de + a*a*ee
+}
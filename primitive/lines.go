@@ -0,0 +1,84 @@
+package primitive
+
+import "github.com/laramiel/primitive/primitive/shape"
+
+// lineBuffer is the scratch space used to combine overlapping scanlines,
+// such as those of a shape and its symmetric or wrapped copies.
+type lineBuffer struct {
+	W, H           int
+	Src            []uint32
+	Dst            []uint32
+	Lines          []shape.Scanline
+	x0, y0, x1, y1 int // bounds of the coverage in Dst
+}
+
+func newLineBuffer(w, h int) *lineBuffer {
+	return &lineBuffer{
+		W: w, H: h,
+		Src:   make([]uint32, w*h),
+		Dst:   make([]uint32, w*h),
+		Lines: make([]shape.Scanline, 0, 4096),
+		x0:    w, y0: h, x1: -1, y1: -1,
+	}
+}
+
+// add covers the pixel with alpha, unless it is already covered more.
+func (buf *lineBuffer) add(x, y int, alpha uint32) {
+	i := y*buf.W + x
+	if alpha <= buf.Dst[i] {
+		return
+	}
+	buf.Dst[i] = alpha
+	buf.x0, buf.x1 = minInt(buf.x0, x), maxInt(buf.x1, x)
+	buf.y0, buf.y1 = minInt(buf.y0, y), maxInt(buf.y1, y)
+}
+
+// lines returns the coverage added so far as scanlines, and clears it.
+// The scanlines are only valid until the buffer is next used.
+func (buf *lineBuffer) lines() []shape.Scanline {
+	w := buf.W
+	result := buf.Lines[:0]
+	for y := buf.y0; y <= buf.y1; y++ {
+		start, alpha := -1, uint32(0)
+		for x := buf.x0; x <= buf.x1+1; x++ {
+			var a uint32
+			if x <= buf.x1 {
+				a = buf.Dst[y*w+x]
+				buf.Dst[y*w+x] = 0
+			}
+			if start >= 0 && a != alpha {
+				result = append(result, shape.Scanline{Y: y, X1: start, X2: x - 1, Alpha: alpha})
+				start = -1
+			}
+			if start < 0 && a != 0 {
+				start, alpha = x, a
+			}
+		}
+	}
+	buf.x0, buf.y0, buf.x1, buf.y1 = w, buf.H, -1, -1
+	buf.Lines = result
+	return result
+}
+
+// rasterizeCopies returns the scanlines of the shape, along with those of
+// its copies when symmetry or tiling is enabled, masked by the opaque area
+// if there is a mask. The buffer is made the first time it is needed.
+func rasterizeCopies(s shape.Shape, rc *shape.RasterContext, buf **lineBuffer, symmetry *Symmetry, mask []uint32) []shape.Scanline {
+	lines := s.Rasterize(rc)
+	if !rc.Tile && symmetry == nil && mask == nil {
+		return lines
+	}
+	if *buf == nil {
+		*buf = newLineBuffer(rc.W, rc.H)
+	}
+	if rc.Tile {
+		lines = wrapLines(*buf, lines)
+	}
+	if symmetry != nil {
+		lines = symmetry.expand(*buf, lines)
+	}
+	if mask != nil {
+		lines = maskLines(*buf, mask, lines)
+	}
+	return lines
+}
@@ -2,6 +2,10 @@ package primitive
 
 import "github.com/laramiel/primitive/primitive/log"
 
+func warn(format string, a ...interface{}) {
+	log.Log(0, "warning: "+format, a...)
+}
+
 func v(format string, a ...interface{}) {
 	log.Log(1, format, a...)
 }
@@ -0,0 +1,252 @@
+package primitive
+
+import (
+	"encoding/json"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// LottieAnimation is how shapes appear in Lottie output.
+type LottieAnimation int
+
+const (
+	LottieFade  LottieAnimation = iota // shapes fade in
+	LottieScale                        // shapes grow from their centers
+)
+
+// ParseLottieAnimation parses the -lottie-anim flag.
+func ParseLottieAnimation(name string) (LottieAnimation, error) {
+	switch name {
+	case "", "fade":
+		return LottieFade, nil
+	case "scale":
+		return LottieScale, nil
+	}
+	return LottieFade, fmt.Errorf("unrecognized lottie animation: %s", name)
+}
+
+// lottieFrameRate is the frames per second of Lottie output.
+const lottieFrameRate = 30
+
+// lottieBlendModes are the Lottie numbers of the blend modes.
+var lottieBlendModes = map[BlendMode]int{
+	BlendNormal:     0,
+	BlendMultiply:   1,
+	BlendScreen:     2,
+	BlendDarken:     4,
+	BlendLighten:    5,
+	BlendDifference: 10,
+	BlendAdd:        16,
+}
+
+// lottieNum rounds a number to the hundredths which Lottie output is
+// written to.
+func lottieNum(x float64) float64 {
+	return math.Round(x*100) / 100
+}
+
+// lottieValue returns a property which is not animated.
+func lottieValue(k interface{}) map[string]interface{} {
+	return map[string]interface{}{"a": 0, "k": k}
+}
+
+// lottieKeyframes returns a property which eases from one value to the
+// other between the frames, holding them before and after.
+func lottieKeyframes(from, to []float64, t0, t1 float64) map[string]interface{} {
+	return map[string]interface{}{"a": 1, "k": []interface{}{
+		map[string]interface{}{"t": lottieNum(t0), "s": from,
+			"o": map[string]interface{}{"x": []float64{0.333}, "y": []float64{0}},
+			"i": map[string]interface{}{"x": []float64{0.667}, "y": []float64{1}}},
+		map[string]interface{}{"t": lottieNum(t1), "s": to},
+	}}
+}
+
+// lottieTransform returns the transform of a layer or group about the
+// anchor, which is a point for a layer and a pair for a group.
+func lottieTransform(anchor []float64, opacity, scale interface{}) map[string]interface{} {
+	return map[string]interface{}{
+		"a": lottieValue(anchor),
+		"p": lottieValue(anchor),
+		"s": scale,
+		"r": lottieValue(0),
+		"o": opacity,
+	}
+}
+
+// lottiePaths returns the subpaths of the path, transformed and scaled to
+// the output, as Lottie shapes with the bounds of their points.
+func lottiePaths(p shape.Path, t affine, scale float64) ([]interface{}, [4]float64) {
+	bounds := [4]float64{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)}
+	at := func(x, y float64) []float64 {
+		x, y = t.apply(x, y)
+		x, y = (x+0.5)*scale, (y+0.5)*scale
+		bounds = [4]float64{math.Min(bounds[0], x), math.Min(bounds[1], y), math.Max(bounds[2], x), math.Max(bounds[3], y)}
+		return []float64{x, y}
+	}
+	// the tangents are relative to their vertex
+	rel := func(p, v []float64) []float64 {
+		return []float64{lottieNum(p[0] - v[0]), lottieNum(p[1] - v[1])}
+	}
+	var shapes []interface{}
+	var vs, ins, outs [][]float64
+	closed := false
+	flush := func() {
+		if len(vs) == 0 {
+			return
+		}
+		n := len(vs) - 1
+		if closed && n > 0 && lottieNum(vs[n][0]) == lottieNum(vs[0][0]) && lottieNum(vs[n][1]) == lottieNum(vs[0][1]) {
+			// the last curve ends at the start, so it closes the path
+			ins[0] = ins[n]
+			vs, ins, outs = vs[:n], ins[:n], outs[:n]
+		}
+		for j := range vs {
+			ins[j], outs[j] = rel(ins[j], vs[j]), rel(outs[j], vs[j])
+			vs[j] = []float64{lottieNum(vs[j][0]), lottieNum(vs[j][1])}
+		}
+		shapes = append(shapes, map[string]interface{}{
+			"ty": "sh",
+			"ks": lottieValue(map[string]interface{}{"v": vs, "i": ins, "o": outs, "c": closed}),
+		})
+		vs, ins, outs, closed = nil, nil, nil, false
+	}
+	for _, c := range p.Commands {
+		switch c.Op {
+		case shape.PathMove:
+			flush()
+			v := at(c.P[0][0], c.P[0][1])
+			vs, ins, outs = append(vs, v), append(ins, v), append(outs, v)
+		case shape.PathLine:
+			v := at(c.P[0][0], c.P[0][1])
+			vs, ins, outs = append(vs, v), append(ins, v), append(outs, v)
+		case shape.PathQuad:
+			// Lottie has only cubic curves
+			last := vs[len(vs)-1]
+			q, v := at(c.P[0][0], c.P[0][1]), at(c.P[1][0], c.P[1][1])
+			outs[len(outs)-1] = []float64{last[0] + (q[0]-last[0])*2/3, last[1] + (q[1]-last[1])*2/3}
+			vs, outs = append(vs, v), append(outs, v)
+			ins = append(ins, []float64{v[0] + (q[0]-v[0])*2/3, v[1] + (q[1]-v[1])*2/3})
+		case shape.PathCubic:
+			outs[len(outs)-1] = at(c.P[0][0], c.P[0][1])
+			i, v := at(c.P[1][0], c.P[1][1]), at(c.P[2][0], c.P[2][1])
+			vs, ins, outs = append(vs, v), append(ins, i), append(outs, v)
+		case shape.PathClose:
+			closed = true
+		}
+	}
+	flush()
+	return shapes, bounds
+}
+
+// lottieLayer returns a layer of the type shown for the whole animation,
+// with its fields.
+func lottieLayer(ty int, name string, total float64, fields map[string]interface{}) map[string]interface{} {
+	layer := map[string]interface{}{
+		"ddd": 0, "ty": ty, "nm": name, "sr": 1, "ao": 0, "ip": 0, "op": lottieNum(total), "st": 0, "bm": 0,
+		"ks": lottieTransform([]float64{0, 0, 0}, lottieValue(100), lottieValue([]float64{100, 100, 100})),
+	}
+	for k, v := range fields {
+		layer[k] = v
+	}
+	return layer
+}
+
+// Lottie returns the model as a Lottie animation the size of the output,
+// in which the shapes appear in the order they were added, fading in or
+// growing from their centers, and which loops. The shapes are split into
+// frames as by Frames, with the timing of SaveGIFImageMagick as for
+// AnimatedSVG, and each shape appears over the time of a frame. Each
+// shape is a shape layer with a filled or stroked group for each copy.
+// Sprites, which have no outline, and the mask of the opaque area are not
+// drawn.
+func (model *Model) Lottie(mode LottieAnimation, scoreDelta float64, delay, lastDelay int) string {
+	sw, sh := model.Sw, model.Sh
+	frames := model.frameEnds(scoreDelta)
+	step := float64(delay) * lottieFrameRate / 100
+	total := float64(len(frames)*delay+lastDelay) * lottieFrameRate / 100
+
+	// the background, below the shapes
+	var layers []map[string]interface{}
+	assets := []interface{}{}
+	var im image.Image
+	if model.initImage != nil {
+		im = model.initImage
+	} else if model.gradient != nil {
+		im = model.gradient.image(sw, sh, model.Scale, 0.5)
+	}
+	switch {
+	case im != nil:
+		assets = append(assets, map[string]interface{}{"id": "background", "w": sw, "h": sh, "u": "", "p": canvasImage(im), "e": 1})
+		layers = append(layers, lottieLayer(2, "background", total, map[string]interface{}{"refId": "background"}))
+	case model.Background.A != 0:
+		bg := model.Background
+		layer := lottieLayer(1, "background", total, map[string]interface{}{"sc": bg.Hex(), "sw": sw, "sh": sh})
+		layer["ks"].(map[string]interface{})["o"] = lottieValue(lottieNum(float64(bg.A) / 255 * 100))
+		layers = append(layers, layer)
+	}
+
+	skipped := 0
+	frame := 0
+	for i, s := range model.Shapes {
+		for frame < len(frames)-1 && i >= frames[frame] {
+			frame++
+		}
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		// frame N is shown after the background and N-1 frames before it
+		t0 := float64(frame+1) * step
+		t1 := math.Min(t0+step, total)
+		c := s.Color
+		color := lottieValue([]float64{lottieNum(float64(c.R) / 255), lottieNum(float64(c.G) / 255), lottieNum(float64(c.B) / 255), 1})
+		opacity := lottieValue(lottieNum(float64(c.A) / 255 * 100))
+		paint := map[string]interface{}{"ty": "fl", "c": color, "o": opacity, "r": 1}
+		if path.Width > 0 {
+			paint = map[string]interface{}{"ty": "st", "c": color, "o": opacity,
+				"w": lottieValue(lottieNum(path.Width * model.Scale)), "lc": 2, "lj": 2}
+		}
+		var groups []interface{}
+		for _, t := range model.copies(s.Shape) {
+			items, b := lottiePaths(path, t, model.Scale)
+			center := []float64{lottieNum((b[0] + b[2]) / 2), lottieNum((b[1] + b[3]) / 2)}
+			scale := lottieValue([]float64{100, 100})
+			if mode == LottieScale {
+				scale = lottieKeyframes([]float64{0, 0}, []float64{100, 100}, t0, t1)
+			}
+			transform := lottieTransform(center, lottieValue(100), scale)
+			transform["ty"] = "tr"
+			items = append(items, paint, transform)
+			groups = append(groups, map[string]interface{}{"ty": "gr", "it": items})
+		}
+		layer := lottieLayer(4, fmt.Sprintf("shape %d", i+1), total, map[string]interface{}{
+			"shapes": groups, "bm": lottieBlendModes[s.Blend]})
+		if mode == LottieFade {
+			layer["ks"].(map[string]interface{})["o"] = lottieKeyframes([]float64{0}, []float64{100}, t0, t1)
+		}
+		layers = append(layers, layer)
+	}
+	if skipped > 0 {
+		warn("lottie: %d shapes without an outline were not drawn\n", skipped)
+	}
+
+	// the first layer is drawn on top
+	for i, j := 0, len(layers)-1; i < j; i, j = i+1, j-1 {
+		layers[i], layers[j] = layers[j], layers[i]
+	}
+	for i, layer := range layers {
+		layer["ind"] = i + 1
+	}
+	data, err := json.Marshal(map[string]interface{}{
+		"v": "5.7.4", "fr": lottieFrameRate, "ip": 0, "op": lottieNum(total), "w": sw, "h": sh,
+		"nm": "primitive", "ddd": 0, "assets": assets, "layers": layers,
+	})
+	if err != nil {
+		panic(err)
+	}
+	return string(data) + "\n"
+}
@@ -0,0 +1,116 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// OpaqueMode is how shapes are fitted to the opaque area of a target with
+// transparency, such as a sticker or a logo.
+type OpaqueMode int
+
+const (
+	OpaqueNone     OpaqueMode = iota
+	OpaqueRestrict            // shapes are drawn only where the target is mostly opaque
+	OpaqueWeight              // the coverage of shapes is scaled by the target's alpha
+)
+
+// ParseOpaqueMode parses the -opaque flag.
+func ParseOpaqueMode(name string) (OpaqueMode, error) {
+	switch name {
+	case "", "none":
+		return OpaqueNone, nil
+	case "restrict":
+		return OpaqueRestrict, nil
+	case "weight":
+		return OpaqueWeight, nil
+	}
+	return OpaqueNone, fmt.Errorf("unrecognized opaque mode: %s", name)
+}
+
+// opaqueMask returns the coverage allowed at each pixel of the target, from
+// 0 to 0xffff, for the mode.
+func opaqueMask(target *image.RGBA, mode OpaqueMode) []uint32 {
+	size := target.Bounds().Size()
+	w, h := size.X, size.Y
+	mask := make([]uint32, w*h)
+	for y := 0; y < h; y++ {
+		i := target.PixOffset(0, y)
+		for x := 0; x < w; x++ {
+			a := uint32(target.Pix[i+3])
+			if mode == OpaqueRestrict {
+				if a >= 0x80 {
+					a = 0xff
+				} else {
+					a = 0
+				}
+			}
+			mask[y*w+x] = a * 0x101
+			i += 4
+		}
+	}
+	return mask
+}
+
+// maskLines returns the scanlines with their coverage scaled by the mask.
+func maskLines(buf *lineBuffer, mask []uint32, lines []shape.Scanline) []shape.Scanline {
+	const m = 0xffff
+	w := buf.W
+	for _, line := range lines {
+		i := line.Y*w + line.X1
+		for x := line.X1; x <= line.X2; x++ {
+			if a := line.Alpha * mask[i] / m; a > 0 {
+				buf.add(x, line.Y, a)
+			}
+			i++
+		}
+	}
+	return buf.lines()
+}
+
+// scaleMask returns the mask resampled to sw by sh pixels, where the target
+// is scaled by scale and offset by half a pixel, as the output is drawn.
+func scaleMask(mask []uint32, w, h, sw, sh int, scale float64) *image.Alpha {
+	at := func(x, y int) float64 {
+		x = clampInt(x, 0, w-1)
+		y = clampInt(y, 0, h-1)
+		return float64(mask[y*w+x]) / 0xffff
+	}
+	im := image.NewAlpha(image.Rect(0, 0, sw, sh))
+	for y := 0; y < sh; y++ {
+		fy := (float64(y)+0.5)/scale - 1
+		y0 := int(math.Floor(fy))
+		ty := fy - float64(y0)
+		for x := 0; x < sw; x++ {
+			fx := (float64(x)+0.5)/scale - 1
+			x0 := int(math.Floor(fx))
+			tx := fx - float64(x0)
+			top := at(x0, y0)*(1-tx) + at(x0+1, y0)*tx
+			bottom := at(x0, y0+1)*(1-tx) + at(x0+1, y0+1)*tx
+			v := top*(1-ty) + bottom*ty
+			im.Pix[im.PixOffset(x, y)] = uint8(v*255 + 0.5)
+		}
+	}
+	return im
+}
+
+// svgMask returns an SVG luminance mask with the id, covering the w by h
+// plane with the mask as an embedded PNG image.
+func svgMask(id string, mask []uint32, w, h int) string {
+	im := image.NewGray(image.Rect(0, 0, w, h))
+	for i, a := range mask {
+		im.Pix[i] = uint8(a >> 8)
+	}
+	var buf bytes.Buffer
+	png.Encode(&buf, im)
+	return fmt.Sprintf(
+		"<mask id=\"%s\" maskUnits=\"userSpaceOnUse\" x=\"0\" y=\"0\" width=\"%d\" height=\"%d\">"+
+			"<image width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\" href=\"data:image/png;base64,%s\" /></mask>",
+		id, w, h, w, h, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
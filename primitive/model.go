@@ -2,8 +2,11 @@ package primitive
 
 import (
 	"fmt"
+	"html"
 	"image"
+	"image/draw"
 	"math/rand"
+	"strconv"
 	"strings"
 	// "time"
 	// "sync/atomic"
@@ -17,6 +20,7 @@ type ScoredShape struct {
 	Shape shape.Shape
 	Color Color
 	Score float64
+	Blend BlendMode
 }
 
 type Model struct {
@@ -28,10 +32,24 @@ type Model struct {
 	Context     *gg.Context
 	RC          shape.RasterContext // Rasterizes the shape into scanlines
 	ColorPicker ColorPicker         // Picks the best color for the input scanlines
-	Score       float64
-	Workers     []*Worker
-	counter     int64
-	Shapes      []ScoredShape
+	Symmetry    *Symmetry           // Copies drawn along with each shape, if any
+	Blend       BlendMode           // How the shapes being added are blended
+	Linear      bool                // Composite in linear light; set before Init
+	Opaque      OpaqueMode          // How shapes are fitted to the target's opaque area
+	layer       *gg.Context
+	initImage   image.Image  // starting image, if not the background color
+	gradient    *Gradient    // background gradient, if not a single color
+	mask        []uint32     // coverage allowed at each pixel, when Opaque is set
+	outputMask  *image.Alpha // mask at the output size
+	// linear-light copies of the images, when Linear is set
+	targetLinear  *linearRGBA
+	currentLinear *linearRGBA
+	canvas        *linearRGBA
+	buffer        *lineBuffer
+	Score         float64
+	Workers       []*Worker
+	counter       int64
+	Shapes        []ScoredShape
 }
 
 func NewModel(target image.Image, background Color, size int, picker ColorPicker) *Model {
@@ -73,19 +91,91 @@ func NewModel(target image.Image, background Color, size int, picker ColorPicker
 }
 
 func (model *Model) Init(numWorkers int, seed int64) {
+	if model.Linear {
+		model.targetLinear = newLinearRGBA(model.Target)
+		model.currentLinear = newLinearRGBA(model.Current)
+		model.canvas = newLinearRGBA(model.Context.Image().(*image.RGBA))
+	}
 	rng := rand.New(rand.NewSource(seed))
 	for i := 0; i < numWorkers; i++ {
 		worker := NewWorker(model.Target, rng.Int63(), model.ColorPicker)
+		worker.Symmetry = model.Symmetry
+		worker.RC.SetTile(model.RC.Tile)
+		worker.mask = model.mask
+		if model.Linear {
+			worker.targetLinear = model.targetLinear
+			worker.bufferLinear = newLinearRGBA(model.Current)
+		}
 		model.Workers = append(model.Workers, worker)
 	}
 }
 
+// initWorker prepares the worker to search for the next shape.
+func (model *Model) initWorker(worker *Worker) {
+	worker.Blend = model.Blend
+	worker.currentLinear = model.currentLinear
+	worker.Init(model.Current, model.Score)
+}
+
+// SetSymmetry makes every shape be drawn and scored along with its
+// symmetric copies. If symmetrize is set, the target is made symmetric
+// first. It must be called before Init.
+func (model *Model) SetSymmetry(symmetry *Symmetry, symmetrize bool) {
+	model.Symmetry = symmetry
+	if symmetry == nil {
+		return
+	}
+	if symmetrize {
+		model.Target = symmetry.Symmetrize(model.Target)
+		model.Score = differenceFull(model.Target, model.Current)
+	}
+}
+
+// SetTile makes the output tileable: shapes crossing an edge of the canvas
+// wrap around to the opposite edge. It must be called before Init.
+func (model *Model) SetTile(tile bool) {
+	model.RC.SetTile(tile)
+}
+
+// SetGradient starts the model from the gradient rather than from the
+// background color. It must be called before Init.
+func (model *Model) SetGradient(g *Gradient) {
+	model.gradient = g
+	model.Current = g.image(model.RC.W, model.RC.H, 1, 0)
+	model.Score = differenceFull(model.Target, model.Current)
+	model.Context = model.newContext()
+}
+
+// SetOpaque fits the shapes to the opaque area of the target: their
+// coverage is masked by the target's alpha, in the output as well as when
+// they are scored. It must be called before Init.
+func (model *Model) SetOpaque(mode OpaqueMode) {
+	model.Opaque = mode
+	if mode == OpaqueNone {
+		return
+	}
+	model.mask = opaqueMask(model.Target, mode)
+	model.outputMask = scaleMask(model.mask, model.RC.W, model.RC.H, model.Sw, model.Sh, model.Scale)
+	model.Context.SetMask(model.outputMask)
+}
+
 func (model *Model) newContext() *gg.Context {
 	dc := gg.NewContext(model.Sw, model.Sh)
 	dc.Scale(model.Scale, model.Scale)
 	dc.Translate(0.5, 0.5)
 	dc.SetColor(model.Background.NRGBA())
 	dc.Clear()
+	if model.gradient != nil {
+		draw.Draw(dc.Image().(*image.RGBA), image.Rect(0, 0, model.Sw, model.Sh),
+			model.gradient.image(model.Sw, model.Sh, model.Scale, 0.5), image.ZP, draw.Src)
+	}
+	if model.initImage != nil {
+		draw.Draw(dc.Image().(*image.RGBA), image.Rect(0, 0, model.Sw, model.Sh),
+			resizeRGBA(model.initImage, model.Sw, model.Sh), image.ZP, draw.Src)
+	}
+	if model.outputMask != nil {
+		dc.SetMask(model.outputMask)
+	}
 	return dc
 }
 
@@ -93,11 +183,14 @@ func (model *Model) Frames(scoreDelta float64) []image.Image {
 	var result []image.Image
 	dc := model.newContext()
 	result = append(result, imageToRGBA(dc.Image()))
+	var canvas *linearRGBA
+	if model.Linear {
+		canvas = newLinearRGBA(dc.Image().(*image.RGBA))
+	}
 	previous := 10.0
 	for _, s := range model.Shapes {
 		c := s.Color
-		dc.SetRGBA255(c.R, c.G, c.B, c.A)
-		s.Shape.Draw(dc, model.Scale)
+		model.paint(dc, canvas, s.Shape, c, s.Blend)
 		dc.Fill()
 		score := s.Score
 		delta := previous - score
@@ -110,34 +203,271 @@ func (model *Model) Frames(scoreDelta float64) []image.Image {
 }
 
 func (model *Model) SVG() string {
+	return model.svg(nil, "")
+}
+
+// svg returns the SVG document of the model. If there are frames, the
+// shapes up to the end of each are grouped with the class fN for the N-th
+// frame, and the style is added to the document.
+func (model *Model) svg(frames []int, style string) string {
 	bg := model.Background
 	var lines []string
-	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"100%%\" height=\"100%%\" preserveAspectRatio=\"none\" viewbox=\"0 0 %d %d\">", model.Sw, model.Sh))
-	lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B))
-	lines = append(lines, fmt.Sprintf("<g transform=\"scale(%f) translate(0.5 0.5)\">", model.Scale))
+	lines = append(lines, fmt.Sprintf("<svg xmlns=\"http://www.w3.org/2000/svg\" version=\"1.1\" width=\"100%%\" height=\"100%%\" preserveAspectRatio=\"none\" viewBox=\"0 0 %d %d\">", model.Sw, model.Sh))
+	if style != "" {
+		lines = append(lines, "<style>", style, "</style>")
+	}
+	if defs := model.svgDefs(); len(defs) > 0 {
+		lines = append(lines, "<defs>")
+		lines = append(lines, defs...)
+		lines = append(lines, "</defs>")
+	}
+	if model.initImage != nil {
+		lines = append(lines, svgImage(model.initImage, model.Sw, model.Sh))
+	} else if model.gradient != nil {
+		_, rects := model.gradient.svg(model.Sw, model.Sh, model.Scale)
+		lines = append(lines, rects...)
+	} else if bg.A != 0 {
+		lines = append(lines, fmt.Sprintf("<rect x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" fill=\"#%02x%02x%02x\" />", model.Sw, model.Sh, bg.R, bg.G, bg.B))
+	}
+	mask := ""
+	if model.mask != nil {
+		mask = " mask=\"url(#opaque)\""
+	}
+	// the most common opacity is inherited from the outer group, if more
+	// than one shape has it
+	counts := make(map[int]int)
+	alpha := 255
 	for _, s := range model.Shapes {
-		c := s.Color
-		attrs := fmt.Sprintf("fill=\"#%02x%02x%02x\" fill-opacity=\"%f\"",
-			c.R, c.G, c.B, float64(c.A)/255)
-		lines = append(lines, s.Shape.SVG(attrs))
+		counts[s.Color.A]++
+		if counts[s.Color.A] > counts[alpha] {
+			alpha = s.Color.A
+		}
+	}
+	if counts[alpha] < 2 {
+		alpha = 255
+	}
+	// the style of each shape, as a fill or as a stroke
+	styles := make([]string, len(model.Shapes))
+	paints := make(map[string]bool)
+	for i, s := range model.Shapes {
+		paint := "fill"
+		if path, ok := shape.ShapePath(s.Shape); ok && path.Width > 0 {
+			paint = "stroke"
+		}
+		styles[i] = model.svgStyle(s, paint, alpha)
+		if paint == "stroke" {
+			styles[i] += " fill=\"none\""
+		}
+		paints[paint] = true
+	}
+	opacity := ""
+	for _, paint := range []string{"fill", "stroke"} {
+		if alpha != 255 && paints[paint] {
+			opacity += fmt.Sprintf(" %s-opacity=\"%s\"", paint, svgOpacity(alpha))
+		}
+	}
+	lines = append(lines, fmt.Sprintf("<g transform=\"scale(%s) translate(0.5 0.5)\"%s%s>",
+		strconv.FormatFloat(model.Scale, 'g', -1, 64), opacity, mask))
+	if frames == nil {
+		lines = append(lines, model.svgShapes(0, len(model.Shapes), styles)...)
+	}
+	start := 0
+	for i, end := range frames {
+		lines = append(lines, fmt.Sprintf("<g class=\"f%d\">", i+1))
+		lines = append(lines, model.svgShapes(start, end, styles)...)
+		lines = append(lines, "</g>")
+		start = end
 	}
 	lines = append(lines, "</g>")
 	lines = append(lines, "</svg>")
 	return strings.Join(lines, "\n")
 }
 
+// svgShapes returns the SVG of the shapes from up to to, with their
+// styles. Consecutive shapes of the same style share a group with it, but
+// blend modes apply to a whole group, so those shapes stand alone.
+func (model *Model) svgShapes(from, to int, styles []string) []string {
+	var lines []string
+	for i := from; i < to; {
+		j := i + 1
+		if model.Shapes[i].Blend == BlendNormal {
+			for j < to && styles[j] == styles[i] && model.Shapes[j].Blend == BlendNormal {
+				j++
+			}
+		}
+		if j-i == 1 {
+			lines = append(lines, model.svgElement(i, styles[i])...)
+		} else {
+			lines = append(lines, fmt.Sprintf("<g %s>", styles[i]))
+			for k := i; k < j; k++ {
+				lines = append(lines, model.svgElement(k, "")...)
+			}
+			lines = append(lines, "</g>")
+		}
+		i = j
+	}
+	return lines
+}
+
+// svgStyle returns the attributes which color the shape, as a fill or as
+// a stroke, leaving out the opacity if it is the inherited alpha.
+func (model *Model) svgStyle(s ScoredShape, paint string, alpha int) string {
+	c := s.Color
+	attrs := fmt.Sprintf("%s=\"#%02x%02x%02x\"", paint, c.R, c.G, c.B)
+	if c.A != alpha {
+		attrs += fmt.Sprintf(" %s-opacity=\"%s\"", paint, svgOpacity(c.A))
+	}
+	if s.Blend != BlendNormal {
+		attrs += fmt.Sprintf(" style=\"mix-blend-mode:%s\"", s.Blend.css())
+	}
+	if namer, ok := model.ColorPicker.(ColorNamer); ok {
+		if name := namer.ColorName(c); name != "" {
+			attrs += fmt.Sprintf(" data-color-name=\"%s\"", html.EscapeString(name))
+		}
+	}
+	return attrs
+}
+
+// svgElement returns the SVG of the i-th shape and its copies, with the
+// attributes, or in the style of its group if there are none.
+func (model *Model) svgElement(i int, attrs string) []string {
+	s := model.Shapes[i].Shape
+	element := s.SVG(attrs)
+	if copies := model.copies(s); len(copies) > 1 {
+		return svgCopies(fmt.Sprintf("s%d", i), element, copies)
+	}
+	return []string{element}
+}
+
+// svgOpacity formats an alpha for SVG, to the three decimals which tell
+// every alpha apart, without a leading zero.
+func svgOpacity(a int) string {
+	if a >= 255 {
+		return "1"
+	}
+	if a <= 0 {
+		return "0"
+	}
+	s := strconv.FormatFloat(float64(a)/255, 'f', 3, 64)
+	return strings.TrimPrefix(strings.TrimRight(s, "0"), "0")
+}
+
+// svgDefs returns the distinct definitions referred to by the shapes, the
+// background gradient and the mask of the opaque area.
+func (model *Model) svgDefs() []string {
+	var defs []string
+	if model.gradient != nil && model.initImage == nil {
+		gradient, _ := model.gradient.svg(model.Sw, model.Sh, model.Scale)
+		defs = append(defs, gradient...)
+	}
+	if model.mask != nil {
+		defs = append(defs, svgMask("opaque", model.mask, model.RC.W, model.RC.H))
+	}
+	seen := make(map[string]bool)
+	for _, s := range model.Shapes {
+		if d, ok := s.Shape.(shape.SVGDefiner); ok {
+			for _, def := range d.SVGDefs() {
+				if !seen[def] {
+					seen[def] = true
+					defs = append(defs, def)
+				}
+			}
+		}
+	}
+	return defs
+}
+
 func (model *Model) Add(shape shape.Shape, alpha int) {
+	lines := model.rasterize(shape)
+	var color Color
+	if model.Linear {
+		color = selectColorLinear(model.ColorPicker, model.Target, model.Current,
+			model.targetLinear, model.currentLinear, lines, alpha)
+	} else {
+		color = selectColor(model.ColorPicker, model.Blend, model.Target, model.Current, lines, alpha)
+	}
+	model.add(shape, color, model.Blend, lines)
+}
+
+// add draws the scanlines of the shape in the color and keeps the shape.
+func (model *Model) add(s shape.Shape, c Color, mode BlendMode, lines []shape.Scanline) {
 	before := copyRGBA(model.Current)
-	lines := shape.Rasterize(&model.RC)
-	color := model.ColorPicker.Select(model.Target, model.Current, lines, alpha)
-	drawLines(model.Current, color, lines)
+	if model.Linear {
+		drawLinesLinear(model.Current, model.currentLinear, c, lines)
+	} else {
+		drawLinesBlend(model.Current, c, lines, mode)
+	}
 	score := differencePartial(model.Target, before, model.Current, model.Score, lines)
 
 	model.Score = score
-	model.Shapes = append(model.Shapes, ScoredShape{shape, color, score})
+	model.Shapes = append(model.Shapes, ScoredShape{s, c, score, mode})
+
+	model.paint(model.Context, model.canvas, s, c, mode)
+}
+
+// paint draws the shape onto dc in the color, with the blend mode. Shapes
+// which are not blended normally, or which are composited in linear light
+// onto the canvas, are drawn into a separate layer first.
+func (model *Model) paint(dc *gg.Context, canvas *linearRGBA, s shape.Shape, c Color, mode BlendMode) {
+	if mode == BlendNormal && canvas == nil {
+		dc.SetRGBA255(c.R, c.G, c.B, c.A)
+		model.draw(dc, s)
+		return
+	}
+	if model.layer == nil {
+		model.layer = gg.NewContext(model.Sw, model.Sh)
+		model.layer.Scale(model.Scale, model.Scale)
+		model.layer.Translate(0.5, 0.5)
+		if model.outputMask != nil {
+			model.layer.SetMask(model.outputMask)
+		}
+	}
+	layer := model.layer
+	layer.SetRGBA(0, 0, 0, 0)
+	layer.Clear()
+	layer.SetRGBA255(c.R, c.G, c.B, c.A)
+	model.draw(layer, s)
+	if canvas != nil {
+		compositeLinear(dc.Image().(*image.RGBA), canvas, layer.Image().(*image.RGBA), c)
+	} else {
+		blendImage(dc.Image().(*image.RGBA), layer.Image().(*image.RGBA), c, mode)
+	}
+}
+
+// rasterize returns the scanlines of the shape, along with those of its
+// copies when symmetry or tiling is enabled, masked by the opaque area.
+func (model *Model) rasterize(s shape.Shape) []shape.Scanline {
+	return rasterizeCopies(s, &model.RC, &model.buffer, model.Symmetry, model.mask)
+}
 
-	model.Context.SetRGBA255(color.R, color.G, color.B, color.A)
-	shape.Draw(model.Context, model.Scale)
+// copies returns the transforms of each copy of the shape which is drawn,
+// starting with the identity.
+func (model *Model) copies(s shape.Shape) []affine {
+	w, h := model.RC.W, model.RC.H
+	copies := []affine{{1, 0, 0, 0, 1, 0}}
+	if model.RC.Tile {
+		copies = tileOffsets(s.Rasterize(&model.RC), w, h)
+	}
+	if model.Symmetry != nil {
+		var result []affine
+		for _, t := range model.Symmetry.transforms(w, h) {
+			for _, u := range copies {
+				result = append(result, t.mul(u))
+			}
+		}
+		copies = result
+	}
+	return copies
+}
+
+// draw draws the shape, along with its copies when symmetry or tiling is
+// enabled.
+func (model *Model) draw(dc *gg.Context, s shape.Shape) {
+	if copies := model.copies(s); len(copies) > 1 {
+		drawCopies(dc, s, model.Scale, copies)
+	} else {
+		s.Draw(dc, model.Scale)
+	}
 }
 
 func (model *Model) Step(factory shape.ShapeFactory, alpha, repeat int) int {
@@ -146,7 +476,7 @@ func (model *Model) Step(factory shape.ShapeFactory, alpha, repeat int) int {
 	model.Add(state.Shape, state.Alpha)
 
 	for i := 0; i < repeat; i++ {
-		state.Worker.Init(model.Current, model.Score)
+		model.initWorker(state.Worker)
 		a := state.Energy()
 		state = HillClimb(state, 100).(*State)
 		b := state.Energy()
@@ -177,7 +507,7 @@ func (model *Model) runWorkers(factory shape.ShapeFactory, a, n, age, m int) *St
 	}
 	for i := 0; i < wn; i++ {
 		worker := model.Workers[i]
-		worker.Init(model.Current, model.Score)
+		model.initWorker(worker)
 		go model.runWorker(worker, factory, a, n, age, wm, ch)
 	}
 	var bestEnergy float64
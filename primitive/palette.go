@@ -0,0 +1,213 @@
+package primitive
+
+import (
+	"image"
+	"math"
+	"math/rand"
+	"sort"
+)
+
+// lab is a color in the CIE L*a*b* space, where the distance between two
+// colors roughly matches how different they look.
+type lab struct {
+	L, A, B float64
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSrgb(v float64) float64 {
+	if v <= 0.0031308 {
+		return v * 12.92
+	}
+	return 1.055*math.Pow(v, 1/2.4) - 0.055
+}
+
+// D65 white point
+const (
+	labXn = 0.95047
+	labYn = 1.0
+	labZn = 1.08883
+)
+
+func labF(t float64) float64 {
+	if t > 216.0/24389 {
+		return math.Cbrt(t)
+	}
+	return (24389.0/27*t + 16) / 116
+}
+
+func labFInv(t float64) float64 {
+	if t*t*t > 216.0/24389 {
+		return t * t * t
+	}
+	return (116*t - 16) * 27 / 24389
+}
+
+func rgbToLab(r, g, b int) lab {
+	lr := srgbToLinear(float64(r) / 255)
+	lg := srgbToLinear(float64(g) / 255)
+	lb := srgbToLinear(float64(b) / 255)
+	x := labF((0.4124564*lr + 0.3575761*lg + 0.1804375*lb) / labXn)
+	y := labF((0.2126729*lr + 0.7151522*lg + 0.0721750*lb) / labYn)
+	z := labF((0.0193339*lr + 0.1191920*lg + 0.9503041*lb) / labZn)
+	return lab{116*y - 16, 500 * (x - y), 200 * (y - z)}
+}
+
+func (c lab) rgb() (r, g, b int) {
+	fy := (c.L + 16) / 116
+	x := labFInv(fy+c.A/500) * labXn
+	y := labFInv(fy) * labYn
+	z := labFInv(fy-c.B/200) * labZn
+	lr := 3.2404542*x - 1.5371385*y - 0.4985314*z
+	lg := -0.9692660*x + 1.8760108*y + 0.0415560*z
+	lb := 0.0556434*x - 0.2040259*y + 1.0572252*z
+	r = clampInt(int(linearToSrgb(lr)*255+0.5), 0, 255)
+	g = clampInt(int(linearToSrgb(lg)*255+0.5), 0, 255)
+	b = clampInt(int(linearToSrgb(lb)*255+0.5), 0, 255)
+	return
+}
+
+func (c lab) distance2(d lab) float64 {
+	dl, da, db := c.L-d.L, c.A-d.A, c.B-d.B
+	return dl*dl + da*da + db*db
+}
+
+// ExtractPalette returns n colors which represent the image, found by
+// k-means clustering of its pixels in L*a*b* space. The colors are sorted
+// by the number of pixels they represent, most first.
+func ExtractPalette(im image.Image, n int) []Color {
+	rgba := imageToRGBA(im)
+
+	// cluster the distinct colors, weighted by how often they occur
+	counts := make(map[[3]uint8]float64)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		counts[[3]uint8{rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2]}]++
+	}
+	points := make([]lab, 0, len(counts))
+	weights := make([]float64, 0, len(counts))
+	for c, w := range counts {
+		points = append(points, rgbToLab(int(c[0]), int(c[1]), int(c[2])))
+		weights = append(weights, w)
+	}
+	// map iteration order is random; sort so the result is repeatable
+	sort.Sort(labPoints{points, weights})
+	if n > len(points) {
+		n = len(points)
+	}
+
+	// k-means++ seeding
+	rnd := rand.New(rand.NewSource(1))
+	centers := []lab{points[weightedIndex(rnd, weights)]}
+	dist := make([]float64, len(points))
+	for i := range dist {
+		dist[i] = math.Inf(1)
+	}
+	for len(centers) < n {
+		last := centers[len(centers)-1]
+		p := make([]float64, len(points))
+		for i, x := range points {
+			dist[i] = math.Min(dist[i], x.distance2(last))
+			p[i] = dist[i] * weights[i]
+		}
+		centers = append(centers, points[weightedIndex(rnd, p)])
+	}
+
+	assignment := make([]int, len(points))
+	totals := make([]float64, n)
+	for iteration := 0; iteration < 50; iteration++ {
+		changed := iteration == 0
+		for i, x := range points {
+			best, bestDist := 0, math.Inf(1)
+			for j, c := range centers {
+				if d := x.distance2(c); d < bestDist {
+					best, bestDist = j, d
+				}
+			}
+			if assignment[i] != best {
+				assignment[i] = best
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		sums := make([]lab, n)
+		for j := range totals {
+			totals[j] = 0
+		}
+		for i, x := range points {
+			j, w := assignment[i], weights[i]
+			sums[j].L += x.L * w
+			sums[j].A += x.A * w
+			sums[j].B += x.B * w
+			totals[j] += w
+		}
+		for j := range centers {
+			if totals[j] > 0 {
+				centers[j] = lab{sums[j].L / totals[j], sums[j].A / totals[j], sums[j].B / totals[j]}
+			}
+		}
+	}
+
+	order := make([]int, n)
+	for j := range order {
+		order[j] = j
+	}
+	sort.SliceStable(order, func(i, j int) bool {
+		return totals[order[i]] > totals[order[j]]
+	})
+	result := make([]Color, n)
+	for i, j := range order {
+		r, g, b := centers[j].rgb()
+		result[i] = Color{r, g, b, 255}
+	}
+	vv("palette: %v\n", result)
+	return result
+}
+
+// weightedIndex picks an index at random with probability proportional to
+// its weight.
+func weightedIndex(rnd *rand.Rand, weights []float64) int {
+	var total float64
+	for _, w := range weights {
+		total += w
+	}
+	x := rnd.Float64() * total
+	for i, w := range weights {
+		x -= w
+		if x < 0 {
+			return i
+		}
+	}
+	return len(weights) - 1
+}
+
+type labPoints struct {
+	points  []lab
+	weights []float64
+}
+
+func (p labPoints) Len() int {
+	return len(p.points)
+}
+
+func (p labPoints) Less(i, j int) bool {
+	a, b := p.points[i], p.points[j]
+	if a.L != b.L {
+		return a.L < b.L
+	}
+	if a.A != b.A {
+		return a.A < b.A
+	}
+	return a.B < b.B
+}
+
+func (p labPoints) Swap(i, j int) {
+	p.points[i], p.points[j] = p.points[j], p.points[i]
+	p.weights[i], p.weights[j] = p.weights[j], p.weights[i]
+}
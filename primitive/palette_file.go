@@ -0,0 +1,379 @@
+package primitive
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+// PaletteEntry is a color loaded from a palette file, along with its name
+// when the file gives one.
+type PaletteEntry struct {
+	Color Color
+	Name  string
+}
+
+// LoadPalette reads a palette from a GIMP (.gpl), Adobe Swatch Exchange
+// (.ase), Photoshop (.aco), JSON or CSV file.
+func LoadPalette(path string) ([]PaletteEntry, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entries []PaletteEntry
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".gpl":
+		entries, err = parseGPL(data)
+	case ".ase":
+		entries, err = parseASE(data)
+	case ".aco":
+		entries, err = parseACO(data)
+	case ".json":
+		entries, err = parsePaletteJSON(data)
+	case ".csv", ".txt":
+		entries, err = parsePaletteCSV(data)
+	default:
+		return nil, fmt.Errorf("unrecognized palette file extension: %s", path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("%s: no colors found", path)
+	}
+	vv("palette %s: %v\n", path, entries)
+	return entries, nil
+}
+
+// parseGPL reads a GIMP palette: a "GIMP Palette" header, then one color
+// per line as "R G B name".
+func parseGPL(data []byte) ([]PaletteEntry, error) {
+	var entries []PaletteEntry
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for i := 0; scanner.Scan(); i++ {
+		line := strings.TrimSpace(scanner.Text())
+		if i == 0 {
+			if line != "GIMP Palette" {
+				return nil, fmt.Errorf("missing GIMP Palette header")
+			}
+			continue
+		}
+		if line == "" || strings.HasPrefix(line, "#") ||
+			strings.HasPrefix(line, "Name:") || strings.HasPrefix(line, "Columns:") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			return nil, fmt.Errorf("invalid line: %s", line)
+		}
+		var rgb [3]int
+		for j := range rgb {
+			v, err := strconv.Atoi(fields[j])
+			if err != nil {
+				return nil, fmt.Errorf("invalid line: %s", line)
+			}
+			rgb[j] = clampInt(v, 0, 255)
+		}
+		name := strings.Join(fields[3:], " ")
+		if name == "Untitled" {
+			name = ""
+		}
+		entries = append(entries, PaletteEntry{Color{rgb[0], rgb[1], rgb[2], 255}, name})
+	}
+	return entries, scanner.Err()
+}
+
+// parseASE reads an Adobe Swatch Exchange file. Groups are flattened.
+func parseASE(data []byte) ([]PaletteEntry, error) {
+	r := bytes.NewReader(data)
+	var header struct {
+		Signature [4]byte
+		Major     uint16
+		Minor     uint16
+		Blocks    uint32
+	}
+	if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if string(header.Signature[:]) != "ASEF" {
+		return nil, fmt.Errorf("missing ASEF signature")
+	}
+	var entries []PaletteEntry
+	for i := 0; i < int(header.Blocks); i++ {
+		var block struct {
+			Type   uint16
+			Length uint32
+		}
+		if err := binary.Read(r, binary.BigEndian, &block); err != nil {
+			return nil, err
+		}
+		body := make([]byte, block.Length)
+		if _, err := io.ReadFull(r, body); err != nil {
+			return nil, err
+		}
+		if block.Type != 0x0001 {
+			// group start and end
+			continue
+		}
+		br := bytes.NewReader(body)
+		var n uint16
+		if err := binary.Read(br, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		name, err := readUTF16(br, int(n))
+		if err != nil {
+			return nil, err
+		}
+		var model [4]byte
+		if _, err := io.ReadFull(br, model[:]); err != nil {
+			return nil, err
+		}
+		var values []float32
+		switch string(model[:]) {
+		case "RGB ", "LAB ":
+			values = make([]float32, 3)
+		case "CMYK":
+			values = make([]float32, 4)
+		case "Gray":
+			values = make([]float32, 1)
+		default:
+			return nil, fmt.Errorf("unsupported color model: %q", model[:])
+		}
+		if err := binary.Read(br, binary.BigEndian, values); err != nil {
+			return nil, err
+		}
+		var c Color
+		switch string(model[:]) {
+		case "RGB ":
+			c = unitColor(float64(values[0]), float64(values[1]), float64(values[2]))
+		case "LAB ":
+			r, g, b := lab{float64(values[0]) * 100, float64(values[1]), float64(values[2])}.rgb()
+			c = Color{r, g, b, 255}
+		case "CMYK":
+			c = cmykColor(float64(values[0]), float64(values[1]), float64(values[2]), float64(values[3]))
+		case "Gray":
+			c = unitColor(float64(values[0]), float64(values[0]), float64(values[0]))
+		}
+		entries = append(entries, PaletteEntry{c, name})
+	}
+	return entries, nil
+}
+
+// parseACO reads a Photoshop color swatch file. When the file has both
+// sections, the names are taken from the second.
+func parseACO(data []byte) ([]PaletteEntry, error) {
+	r := bytes.NewReader(data)
+	var entries []PaletteEntry
+	for section := 1; section <= 2; section++ {
+		var header struct {
+			Version uint16
+			Count   uint16
+		}
+		if err := binary.Read(r, binary.BigEndian, &header); err != nil {
+			if err == io.EOF && section == 2 {
+				break
+			}
+			return nil, err
+		}
+		if int(header.Version) != section {
+			return nil, fmt.Errorf("unexpected version %d", header.Version)
+		}
+		var result []PaletteEntry
+		for i := 0; i < int(header.Count); i++ {
+			var swatch struct {
+				Space uint16
+				W     uint16
+				X     uint16
+				Y     uint16
+				Z     uint16
+			}
+			if err := binary.Read(r, binary.BigEndian, &swatch); err != nil {
+				return nil, err
+			}
+			var name string
+			if section == 2 {
+				var n uint32
+				if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+					return nil, err
+				}
+				var err error
+				if name, err = readUTF16(r, int(n)); err != nil {
+					return nil, err
+				}
+			}
+			w, x, y, z := float64(swatch.W), float64(swatch.X), float64(swatch.Y), float64(swatch.Z)
+			var c Color
+			switch swatch.Space {
+			case 0: // RGB
+				c = unitColor(w/65535, x/65535, y/65535)
+			case 1: // HSB
+				c = hsbColor(w/65535*360, x/65535, y/65535)
+			case 2: // CMYK, where 0 is full ink
+				c = cmykColor(1-w/65535, 1-x/65535, 1-y/65535, 1-z/65535)
+			case 7: // Lab
+				r, g, b := lab{w / 100, float64(int16(swatch.X)) / 100, float64(int16(swatch.Y)) / 100}.rgb()
+				c = Color{r, g, b, 255}
+			case 8: // grayscale, where 10000 is black
+				v := 1 - w/10000
+				c = unitColor(v, v, v)
+			default:
+				return nil, fmt.Errorf("unsupported color space %d", swatch.Space)
+			}
+			result = append(result, PaletteEntry{c, name})
+		}
+		entries = result
+	}
+	return entries, nil
+}
+
+// parsePaletteJSON reads either a list of colors, each a hex string or an
+// object with "name" and "color" (or "hex") keys, or an object mapping
+// names to hex strings.
+func parsePaletteJSON(data []byte) ([]PaletteEntry, error) {
+	var list []json.RawMessage
+	if err := json.Unmarshal(data, &list); err != nil {
+		var named map[string]string
+		if json.Unmarshal(data, &named) != nil {
+			return nil, err
+		}
+		var entries []PaletteEntry
+		for name, hex := range named {
+			entries = append(entries, PaletteEntry{MakeHexColor(hex), name})
+		}
+		// map iteration order is random
+		sort.Slice(entries, func(i, j int) bool {
+			return entries[i].Name < entries[j].Name
+		})
+		return entries, nil
+	}
+	var entries []PaletteEntry
+	for _, item := range list {
+		var hex string
+		if json.Unmarshal(item, &hex) == nil {
+			entries = append(entries, PaletteEntry{MakeHexColor(hex), ""})
+			continue
+		}
+		var obj struct {
+			Name  string
+			Color string
+			Hex   string
+		}
+		if err := json.Unmarshal(item, &obj); err != nil {
+			return nil, err
+		}
+		if obj.Color == "" {
+			obj.Color = obj.Hex
+		}
+		if obj.Color == "" {
+			return nil, fmt.Errorf("missing color: %s", item)
+		}
+		entries = append(entries, PaletteEntry{MakeHexColor(obj.Color), obj.Name})
+	}
+	return entries, nil
+}
+
+var hexColorPattern = regexp.MustCompile(`^#?([0-9a-fA-F]{3}|[0-9a-fA-F]{6})$`)
+
+// parsePaletteCSV reads one color per record, given either as a hex cell
+// (a "#" is needed if the digits are all decimal) or as three integer
+// cells. The first other non-empty cell is the name.
+// Records without a color, such as a header, are skipped.
+func parsePaletteCSV(data []byte) ([]PaletteEntry, error) {
+	reader := csv.NewReader(bytes.NewReader(data))
+	reader.FieldsPerRecord = -1
+	reader.TrimLeadingSpace = true
+	records, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	var entries []PaletteEntry
+	for _, record := range records {
+		var c *Color
+		var name string
+		var ints []int
+		for _, field := range record {
+			field = strings.TrimSpace(field)
+			if field == "" {
+				continue
+			}
+			v, err := strconv.Atoi(field)
+			if c == nil && err != nil && hexColorPattern.MatchString(field) {
+				x := MakeHexColor(field)
+				c = &x
+				continue
+			}
+			if err == nil {
+				ints = append(ints, v)
+				continue
+			}
+			if name == "" {
+				name = field
+			}
+		}
+		if c == nil && len(ints) >= 3 {
+			c = &Color{clampInt(ints[0], 0, 255), clampInt(ints[1], 0, 255), clampInt(ints[2], 0, 255), 255}
+		}
+		if c != nil {
+			entries = append(entries, PaletteEntry{*c, name})
+		}
+	}
+	return entries, nil
+}
+
+// readUTF16 reads n big-endian UTF-16 code units, including a trailing
+// null.
+func readUTF16(r io.Reader, n int) (string, error) {
+	units := make([]uint16, n)
+	if err := binary.Read(r, binary.BigEndian, units); err != nil {
+		return "", err
+	}
+	for len(units) > 0 && units[len(units)-1] == 0 {
+		units = units[:len(units)-1]
+	}
+	return string(utf16.Decode(units)), nil
+}
+
+func unitColor(r, g, b float64) Color {
+	return Color{
+		clampInt(int(math.Round(r*255)), 0, 255),
+		clampInt(int(math.Round(g*255)), 0, 255),
+		clampInt(int(math.Round(b*255)), 0, 255),
+		255,
+	}
+}
+
+func cmykColor(c, m, y, k float64) Color {
+	return unitColor((1-c)*(1-k), (1-m)*(1-k), (1-y)*(1-k))
+}
+
+func hsbColor(h, s, v float64) Color {
+	h = math.Mod(h, 360) / 60
+	f := h - math.Floor(h)
+	p, q, t := v*(1-s), v*(1-s*f), v*(1-s*(1-f))
+	switch int(h) {
+	case 0:
+		return unitColor(v, t, p)
+	case 1:
+		return unitColor(q, v, p)
+	case 2:
+		return unitColor(p, v, t)
+	case 3:
+		return unitColor(p, q, v)
+	case 4:
+		return unitColor(t, p, v)
+	default:
+		return unitColor(v, p, q)
+	}
+}
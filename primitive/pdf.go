@@ -0,0 +1,283 @@
+package primitive
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// page sizes in points, portrait
+var pageSizes = map[string][2]float64{
+	"a3":     {841.89, 1190.55},
+	"a4":     {595.28, 841.89},
+	"a5":     {419.53, 595.28},
+	"letter": {612, 792},
+	"legal":  {612, 1008},
+}
+
+// ParsePageSize parses the -page flag: a named size such as "a4" or
+// "letter", or WxH with a unit of mm, in or pt, such as "100x150mm". It
+// returns the size in points, or zero for a page the size of the output.
+func ParsePageSize(value string) (float64, float64, error) {
+	value = strings.ToLower(value)
+	if value == "" {
+		return 0, 0, nil
+	}
+	if size, ok := pageSizes[value]; ok {
+		return size[0], size[1], nil
+	}
+	units := map[string]float64{"mm": 72 / 25.4, "in": 72, "pt": 1}
+	for unit, k := range units {
+		if !strings.HasSuffix(value, unit) {
+			continue
+		}
+		parts := strings.Split(strings.TrimSuffix(value, unit), "x")
+		if len(parts) != 2 {
+			break
+		}
+		w, err1 := strconv.ParseFloat(parts[0], 64)
+		h, err2 := strconv.ParseFloat(parts[1], 64)
+		if err1 != nil || err2 != nil || w <= 0 || h <= 0 {
+			break
+		}
+		return w * k, h * k, nil
+	}
+	return 0, 0, fmt.Errorf("unrecognized page size: %s", value)
+}
+
+// pdfBlendModes are the PDF names of the blend modes. PDF has no additive
+// mode, so add is drawn as normal.
+var pdfBlendModes = map[BlendMode]string{
+	BlendNormal:     "Normal",
+	BlendMultiply:   "Multiply",
+	BlendScreen:     "Screen",
+	BlendAdd:        "Normal",
+	BlendLighten:    "Lighten",
+	BlendDarken:     "Darken",
+	BlendDifference: "Difference",
+}
+
+// pdfNum formats a number for a PDF content stream.
+func pdfNum(x float64) string {
+	s := strconv.FormatFloat(x, 'f', 3, 64)
+	s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	if s == "-0" || s == "" {
+		return "0"
+	}
+	return s
+}
+
+func pdfNums(xs ...float64) string {
+	s := make([]string, len(xs))
+	for i, x := range xs {
+		s[i] = pdfNum(x)
+	}
+	return strings.Join(s, " ")
+}
+
+func pdfColor(c Color) string {
+	return pdfNums(float64(c.R)/255, float64(c.G)/255, float64(c.B)/255)
+}
+
+// pdfPath writes the operators which construct the path.
+func pdfPath(buf *bytes.Buffer, p shape.Path) {
+	var x, y float64
+	for _, c := range p.Commands {
+		switch c.Op {
+		case shape.PathMove:
+			x, y = c.P[0][0], c.P[0][1]
+			fmt.Fprintf(buf, "%s m\n", pdfNums(x, y))
+		case shape.PathLine:
+			x, y = c.P[0][0], c.P[0][1]
+			fmt.Fprintf(buf, "%s l\n", pdfNums(x, y))
+		case shape.PathQuad:
+			// PDF has only cubic curves
+			qx, qy := c.P[0][0], c.P[0][1]
+			ex, ey := c.P[1][0], c.P[1][1]
+			fmt.Fprintf(buf, "%s c\n", pdfNums(
+				x+(qx-x)*2/3, y+(qy-y)*2/3, ex+(qx-ex)*2/3, ey+(qy-ey)*2/3, ex, ey))
+			x, y = ex, ey
+		case shape.PathCubic:
+			x, y = c.P[2][0], c.P[2][1]
+			fmt.Fprintf(buf, "%s c\n", pdfNums(
+				c.P[0][0], c.P[0][1], c.P[1][0], c.P[1][1], x, y))
+		case shape.PathClose:
+			buf.WriteString("h\n")
+		}
+	}
+}
+
+// pdfDocument collects the numbered objects of a PDF file.
+type pdfDocument struct {
+	objects []string
+}
+
+// add adds the object and returns its number.
+func (d *pdfDocument) add(object string) int {
+	d.objects = append(d.objects, object)
+	return len(d.objects)
+}
+
+// stream adds a stream object, compressed, with the extra dictionary
+// entries, and returns its number.
+func (d *pdfDocument) stream(dict string, data []byte) int {
+	var z bytes.Buffer
+	w := zlib.NewWriter(&z)
+	w.Write(data)
+	w.Close()
+	return d.add(fmt.Sprintf("<< %s /Filter /FlateDecode /Length %d >>\nstream\n%s\nendstream",
+		dict, z.Len(), z.String()))
+}
+
+func (d *pdfDocument) bytes() []byte {
+	var buf bytes.Buffer
+	buf.WriteString("%PDF-1.4\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make([]int, len(d.objects))
+	for i, object := range d.objects {
+		offsets[i] = buf.Len()
+		fmt.Fprintf(&buf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := buf.Len()
+	fmt.Fprintf(&buf, "xref\n0 %d\n0000000000 65535 f \n", len(d.objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&buf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&buf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(d.objects)+1, xref)
+	return buf.Bytes()
+}
+
+// pdfImage adds the image as an RGB image XObject and returns its number.
+func (d *pdfDocument) pdfImage(im image.Image) int {
+	rgba := imageToRGBA(im)
+	size := rgba.Bounds().Size()
+	data := make([]byte, 0, size.X*size.Y*3)
+	for i := 0; i < len(rgba.Pix); i += 4 {
+		data = append(data, rgba.Pix[i], rgba.Pix[i+1], rgba.Pix[i+2])
+	}
+	return d.stream(fmt.Sprintf(
+		"/Type /XObject /Subtype /Image /Width %d /Height %d /ColorSpace /DeviceRGB /BitsPerComponent 8",
+		size.X, size.Y), data)
+}
+
+// pdfShading returns the shading dictionary which draws the gradient, where
+// plane point p is at (p+0.5)*scale.
+func (d *pdfDocument) pdfShading(g *Gradient, scale float64) string {
+	out := func(v float64) float64 {
+		return (v + 0.5) * scale
+	}
+	colors := func(a, b Color) string {
+		return fmt.Sprintf("/Function << /FunctionType 2 /Domain [0 1] /C0 [%s] /C1 [%s] /N 1 >>",
+			pdfColor(a), pdfColor(b))
+	}
+	switch g.Mode {
+	case GradientRadial:
+		return fmt.Sprintf("<< /ShadingType 3 /ColorSpace /DeviceRGB /Coords [%s] %s /Extend [true true] >>",
+			pdfNums(out(g.X1), out(g.Y1), 0, out(g.X1), out(g.Y1), g.R*scale), colors(g.Colors[0], g.Colors[1]))
+	case GradientQuad:
+		// a sampled function interpolates the corners bilinearly
+		var samples []byte
+		for _, c := range g.Colors {
+			samples = append(samples, uint8(c.R), uint8(c.G), uint8(c.B))
+		}
+		f := d.stream("/FunctionType 0 /Domain [0 1 0 1] /Range [0 1 0 1 0 1] /Size [2 2] /BitsPerSample 8", samples)
+		x1, y1, x2, y2 := out(g.X1), out(g.Y1), out(g.X2), out(g.Y2)
+		return fmt.Sprintf("<< /ShadingType 1 /ColorSpace /DeviceRGB /Domain [0 1 0 1] /Matrix [%s] /Function %d 0 R >>",
+			pdfNums(x2-x1, 0, 0, y2-y1, x1, y1), f)
+	}
+	return fmt.Sprintf("<< /ShadingType 2 /ColorSpace /DeviceRGB /Coords [%s] %s /Extend [true true] >>",
+		pdfNums(out(g.X1), out(g.Y1), out(g.X2), out(g.Y2)), colors(g.Colors[0], g.Colors[1]))
+}
+
+// PDF returns the model as a PDF document with a single page. The page is
+// pageWidth by pageHeight points, turned to match the orientation of the
+// output, with the output centered on it; if they are zero, the page is
+// the size of the output, at a point per pixel. Sprites, which have no
+// outline, and the mask of the opaque area are not drawn.
+func (model *Model) PDF(pageWidth, pageHeight float64) []byte {
+	sw, sh := float64(model.Sw), float64(model.Sh)
+	if pageWidth <= 0 || pageHeight <= 0 {
+		pageWidth, pageHeight = sw, sh
+	} else if (pageWidth > pageHeight) != (sw > sh) {
+		pageWidth, pageHeight = pageHeight, pageWidth
+	}
+	k := pageWidth / sw
+	if pageHeight/sh < k {
+		k = pageHeight / sh
+	}
+
+	d := &pdfDocument{}
+	d.add("<< /Type /Catalog /Pages 2 0 R >>")
+	d.add("") // the pages, once the page is known
+	var resources []string
+	var content bytes.Buffer
+
+	// the output is drawn with y down, centered on the page
+	fmt.Fprintf(&content, "q\n%s cm\n", pdfNums(k, 0, 0, -k, (pageWidth-sw*k)/2, (pageHeight+sh*k)/2))
+	bg := model.Background
+	switch {
+	case model.initImage != nil:
+		im := d.pdfImage(model.initImage)
+		resources = append(resources, fmt.Sprintf("/XObject << /Im0 %d 0 R >>", im))
+		fmt.Fprintf(&content, "q\n%s cm\n/Im0 Do\nQ\n", pdfNums(sw, 0, 0, -sh, 0, sh))
+	case model.gradient != nil:
+		resources = append(resources, fmt.Sprintf("/Shading << /Sh0 %s >>", d.pdfShading(model.gradient, model.Scale)))
+		fmt.Fprintf(&content, "q\n0 0 %s re W n\n/Sh0 sh\nQ\n", pdfNums(sw, sh))
+	case bg.A != 0:
+		fmt.Fprintf(&content, "%s rg\n0 0 %s re f\n", pdfColor(bg), pdfNums(sw, sh))
+	}
+
+	// the shapes, in plane units
+	fmt.Fprintf(&content, "%s cm\n1 J\n1 j\n", pdfNums(model.Scale, 0, 0, model.Scale, model.Scale/2, model.Scale/2))
+	states := make(map[string]string)
+	var stateDicts []string
+	skipped := 0
+	for _, s := range model.Shapes {
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		c := s.Color
+		key := fmt.Sprintf("<< /ca %s /CA %s /BM /%s >>",
+			pdfNum(float64(c.A)/255), pdfNum(float64(c.A)/255), pdfBlendModes[s.Blend])
+		name, ok := states[key]
+		if !ok {
+			name = fmt.Sprintf("GS%d", len(states))
+			states[key] = name
+			stateDicts = append(stateDicts, fmt.Sprintf("/%s %s", name, key))
+		}
+		fmt.Fprintf(&content, "/%s gs\n", name)
+		if path.Width > 0 {
+			fmt.Fprintf(&content, "%s RG\n%s w\n", pdfColor(c), pdfNum(path.Width))
+		} else {
+			fmt.Fprintf(&content, "%s rg\n", pdfColor(c))
+		}
+		for _, t := range model.copies(s.Shape) {
+			fmt.Fprintf(&content, "q\n%s cm\n", pdfNums(t[0], t[3], t[1], t[4], t[2], t[5]))
+			pdfPath(&content, path)
+			if path.Width > 0 {
+				content.WriteString("S\nQ\n")
+			} else {
+				content.WriteString("f\nQ\n")
+			}
+		}
+	}
+	content.WriteString("Q\n")
+	if skipped > 0 {
+		v("pdf: %d shapes without an outline were not drawn\n", skipped)
+	}
+	if len(stateDicts) > 0 {
+		resources = append(resources, fmt.Sprintf("/ExtGState << %s >>", strings.Join(stateDicts, " ")))
+	}
+
+	contents := d.stream("", content.Bytes())
+	page := d.add(fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 %s] /Resources << %s >> /Contents %d 0 R >>",
+		pdfNums(pageWidth, pageHeight), strings.Join(resources, " "), contents))
+	d.objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%d 0 R] /Count 1 >>", page)
+	return d.bytes()
+}
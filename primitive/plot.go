@@ -0,0 +1,376 @@
+package primitive
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// Plotter is the setup of a pen plotter for .hpgl and .gcode outputs.
+type Plotter struct {
+	Pens          []Color // the colors of the pens, numbered from 1
+	Width, Height float64 // the page in points, or zero for the size of the output
+	Feed          float64 // drawing speed in mm per minute
+	Hatch         float64 // spacing of the hatching of filled shapes in mm
+}
+
+// G-code heights of the pen in mm
+const (
+	plotPenUp   = 5
+	plotPenDown = 0
+)
+
+// curves are drawn as this many segments
+const plotCurveSegments = 16
+
+type plotPoint struct {
+	X, Y float64
+}
+
+// plotGroup is the polylines drawn for one copy of a shape, in the order
+// they are drawn, in mm with y up.
+type plotGroup [][]plotPoint
+
+func (g plotGroup) start() plotPoint {
+	return g[0][0]
+}
+
+func (g plotGroup) end() plotPoint {
+	last := g[len(g)-1]
+	return last[len(last)-1]
+}
+
+// reverse returns the group drawn backward.
+func (g plotGroup) reverse() plotGroup {
+	r := make(plotGroup, len(g))
+	for i, line := range g {
+		rline := make([]plotPoint, len(line))
+		for j, p := range line {
+			rline[len(line)-1-j] = p
+		}
+		r[len(g)-1-i] = rline
+	}
+	return r
+}
+
+// flattenPath returns the subpaths of the path as polylines, and whether
+// each is closed.
+func flattenPath(path shape.Path) ([][]plotPoint, []bool) {
+	var lines [][]plotPoint
+	var closed []bool
+	var line []plotPoint
+	flush := func(isClosed bool) {
+		if len(line) > 1 {
+			lines = append(lines, line)
+			closed = append(closed, isClosed)
+		}
+		line = nil
+	}
+	var x, y float64
+	for _, c := range path.Commands {
+		switch c.Op {
+		case shape.PathMove:
+			flush(false)
+			x, y = c.P[0][0], c.P[0][1]
+			line = []plotPoint{{x, y}}
+		case shape.PathLine:
+			x, y = c.P[0][0], c.P[0][1]
+			line = append(line, plotPoint{x, y})
+		case shape.PathQuad:
+			for i := 1; i <= plotCurveSegments; i++ {
+				t := float64(i) / plotCurveSegments
+				u := 1 - t
+				line = append(line, plotPoint{
+					u*u*x + 2*u*t*c.P[0][0] + t*t*c.P[1][0],
+					u*u*y + 2*u*t*c.P[0][1] + t*t*c.P[1][1],
+				})
+			}
+			x, y = c.P[1][0], c.P[1][1]
+		case shape.PathCubic:
+			for i := 1; i <= plotCurveSegments; i++ {
+				t := float64(i) / plotCurveSegments
+				u := 1 - t
+				line = append(line, plotPoint{
+					u*u*u*x + 3*u*u*t*c.P[0][0] + 3*u*t*t*c.P[1][0] + t*t*t*c.P[2][0],
+					u*u*u*y + 3*u*u*t*c.P[0][1] + 3*u*t*t*c.P[1][1] + t*t*t*c.P[2][1],
+				})
+			}
+			x, y = c.P[2][0], c.P[2][1]
+		case shape.PathClose:
+			if len(line) > 0 {
+				line = append(line, line[0])
+			}
+			flush(true)
+		}
+	}
+	flush(false)
+	return lines, closed
+}
+
+// hatch returns horizontal hatching of the closed polylines, by the
+// even-odd rule, with lines at multiples of spacing so that the hatching
+// of neighboring shapes lines up. Alternate lines run in opposite
+// directions.
+func hatch(lines [][]plotPoint, spacing float64) [][]plotPoint {
+	y0, y1 := math.Inf(1), math.Inf(-1)
+	for _, line := range lines {
+		for _, p := range line {
+			y0, y1 = math.Min(y0, p.Y), math.Max(y1, p.Y)
+		}
+	}
+	var result [][]plotPoint
+	forward := true
+	for y := math.Ceil(y0/spacing) * spacing; y <= y1; y += spacing {
+		var xs []float64
+		for _, line := range lines {
+			for i := 1; i < len(line); i++ {
+				a, b := line[i-1], line[i]
+				if (a.Y <= y) != (b.Y <= y) {
+					xs = append(xs, a.X+(y-a.Y)*(b.X-a.X)/(b.Y-a.Y))
+				}
+			}
+		}
+		sort.Float64s(xs)
+		var row [][]plotPoint
+		for i := 0; i+1 < len(xs); i += 2 {
+			row = append(row, []plotPoint{{xs[i], y}, {xs[i+1], y}})
+		}
+		if !forward {
+			row = plotGroup(row).reverse()
+		}
+		result = append(result, row...)
+		forward = !forward
+	}
+	return result
+}
+
+// clipLine returns the parts of the polyline inside the rectangle.
+func clipLine(line []plotPoint, x0, y0, x1, y1 float64) [][]plotPoint {
+	var result [][]plotPoint
+	var part []plotPoint
+	for i := 1; i < len(line); i++ {
+		a, b := line[i-1], line[i]
+		// Liang-Barsky
+		t0, t1 := 0.0, 1.0
+		dx, dy := b.X-a.X, b.Y-a.Y
+		inside := true
+		for _, e := range [4][2]float64{{-dx, a.X - x0}, {dx, x1 - a.X}, {-dy, a.Y - y0}, {dy, y1 - a.Y}} {
+			p, q := e[0], e[1]
+			if p == 0 {
+				if q < 0 {
+					inside = false
+				}
+				continue
+			}
+			r := q / p
+			if p < 0 {
+				t0 = math.Max(t0, r)
+			} else {
+				t1 = math.Min(t1, r)
+			}
+		}
+		if !inside || t0 > t1 {
+			if len(part) > 1 {
+				result = append(result, part)
+			}
+			part = nil
+			continue
+		}
+		if part == nil || t0 > 0 {
+			if len(part) > 1 {
+				result = append(result, part)
+			}
+			part = []plotPoint{{a.X + t0*dx, a.Y + t0*dy}}
+		}
+		part = append(part, plotPoint{a.X + t1*dx, a.Y + t1*dy})
+		if t1 < 1 {
+			result = append(result, part)
+			part = nil
+		}
+	}
+	if len(part) > 1 {
+		result = append(result, part)
+	}
+	return result
+}
+
+// nearestPen returns the index of the pen closest in color.
+func (p *Plotter) nearestPen(c Color) int {
+	best, bestDist := 0, math.MaxInt32
+	for i, q := range p.Pens {
+		dr, dg, db := c.R-q.R, c.G-q.G, c.B-q.B
+		if d := dr*dr + dg*dg + db*db; d < bestDist {
+			best, bestDist = i, d
+		}
+	}
+	return best
+}
+
+// plot returns the groups drawn by each pen, ordered to keep the travel
+// with the pen up short, and the size of the page in mm.
+func (model *Model) plot(p *Plotter) ([][]plotGroup, float64, float64) {
+	const mm = 72 / 25.4
+	sw, sh := float64(model.Sw), float64(model.Sh)
+	pageWidth, pageHeight := p.Width, p.Height
+	if pageWidth <= 0 || pageHeight <= 0 {
+		pageWidth, pageHeight = sw, sh
+	} else if (pageWidth > pageHeight) != (sw > sh) {
+		pageWidth, pageHeight = pageHeight, pageWidth
+	}
+	pageWidth, pageHeight = pageWidth/mm, pageHeight/mm
+	k := math.Min(pageWidth/sw, pageHeight/sh)
+	ox, oy := (pageWidth-sw*k)/2, (pageHeight-sh*k)/2
+	// plane units to mm, with y up
+	toPage := func(x, y float64) plotPoint {
+		return plotPoint{ox + (x+0.5)*model.Scale*k, pageHeight - oy - (y+0.5)*model.Scale*k}
+	}
+
+	pens := make([][]plotGroup, len(p.Pens))
+	skipped := 0
+	for _, s := range model.Shapes {
+		path, ok := shape.ShapePath(s.Shape)
+		if !ok {
+			skipped++
+			continue
+		}
+		pen := p.nearestPen(s.Color)
+		lines, closed := flattenPath(path)
+		for _, t := range model.copies(s.Shape) {
+			var group plotGroup
+			var outlines [][]plotPoint
+			for i, line := range lines {
+				page := make([]plotPoint, len(line))
+				for j, q := range line {
+					page[j] = toPage(t.apply(q.X, q.Y))
+				}
+				group = append(group, page)
+				if closed[i] {
+					outlines = append(outlines, page)
+				}
+			}
+			if path.Width == 0 && len(outlines) > 0 && s.Color.A > 0 {
+				// sparser hatching for more translucent shapes
+				group = append(group, hatch(outlines, p.Hatch*255/float64(s.Color.A))...)
+			}
+			// the plotter stays on the output area
+			var clipped plotGroup
+			for _, line := range group {
+				clipped = append(clipped, clipLine(line, ox, oy, pageWidth-ox, pageHeight-oy)...)
+			}
+			if len(clipped) > 0 {
+				pens[pen] = append(pens[pen], clipped)
+			}
+		}
+	}
+	if skipped > 0 {
+		warn("plot: %d shapes without an outline were not drawn\n", skipped)
+	}
+	for i, groups := range pens {
+		pens[i] = orderGroups(groups)
+	}
+	return pens, pageWidth, pageHeight
+}
+
+// orderGroups orders the groups greedily, drawing next whichever group,
+// forward or backward, starts nearest to where the last one ended.
+func orderGroups(groups []plotGroup) []plotGroup {
+	result := make([]plotGroup, 0, len(groups))
+	used := make([]bool, len(groups))
+	var at plotPoint
+	dist := func(a, b plotPoint) float64 {
+		return math.Hypot(a.X-b.X, a.Y-b.Y)
+	}
+	for range groups {
+		best, bestDist, reverse := -1, math.Inf(1), false
+		for i, g := range groups {
+			if used[i] {
+				continue
+			}
+			if d := dist(at, g.start()); d < bestDist {
+				best, bestDist, reverse = i, d, false
+			}
+			if d := dist(at, g.end()); d < bestDist {
+				best, bestDist, reverse = i, d, true
+			}
+		}
+		used[best] = true
+		g := groups[best]
+		if reverse {
+			g = g.reverse()
+		}
+		result = append(result, g)
+		at = g.end()
+	}
+	return result
+}
+
+// HPGL returns the model as HP-GL for a pen plotter. Each shape is drawn
+// with the pen nearest to its color: strokes along their center and
+// filled shapes as their outline and hatching.
+func (model *Model) HPGL(p *Plotter) string {
+	// plotter units per mm
+	const units = 40
+	pens, _, _ := model.plot(p)
+	var buf bytes.Buffer
+	buf.WriteString("IN;\n")
+	if p.Feed > 0 {
+		// in cm per second
+		fmt.Fprintf(&buf, "VS%s;\n", pdfNum(p.Feed/600))
+	}
+	for i, groups := range pens {
+		if len(groups) == 0 {
+			continue
+		}
+		fmt.Fprintf(&buf, "SP%d;\n", i+1)
+		for _, g := range groups {
+			for _, line := range g {
+				fmt.Fprintf(&buf, "PU%d,%d;PD", int(math.Round(line[0].X*units)), int(math.Round(line[0].Y*units)))
+				for j, q := range line[1:] {
+					if j > 0 {
+						buf.WriteByte(',')
+					}
+					fmt.Fprintf(&buf, "%d,%d", int(math.Round(q.X*units)), int(math.Round(q.Y*units)))
+				}
+				buf.WriteString(";\n")
+			}
+		}
+	}
+	buf.WriteString("PU;SP0;\n")
+	return buf.String()
+}
+
+// GCode returns the model as G-code for a pen plotter, with the pen raised
+// and lowered along Z and a pause to change pens between colors. Each
+// shape is drawn with the pen nearest to its color: strokes along their
+// center and filled shapes as their outline and hatching.
+func (model *Model) GCode(p *Plotter) string {
+	pens, pageWidth, pageHeight := model.plot(p)
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "; %s x %s mm\nG21\nG90\nG0 Z%d\n", pdfNum(pageWidth), pdfNum(pageHeight), plotPenUp)
+	first := true
+	for i, groups := range pens {
+		if len(groups) == 0 {
+			continue
+		}
+		if !first {
+			buf.WriteString("G0 X0 Y0\n")
+		}
+		fmt.Fprintf(&buf, "M0 ; pen %d %s\n", i+1, p.Pens[i].Hex())
+		first = false
+		for _, g := range groups {
+			for _, line := range g {
+				fmt.Fprintf(&buf, "G0 X%s Y%s\nG1 Z%d F%s\n",
+					pdfNum(line[0].X), pdfNum(line[0].Y), plotPenDown, pdfNum(p.Feed))
+				for _, q := range line[1:] {
+					fmt.Fprintf(&buf, "G1 X%s Y%s\n", pdfNum(q.X), pdfNum(q.Y))
+				}
+				fmt.Fprintf(&buf, "G0 Z%d\n", plotPenUp)
+			}
+		}
+	}
+	buf.WriteString("G0 X0 Y0\nM2\n")
+	return buf.String()
+}
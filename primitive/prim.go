@@ -0,0 +1,512 @@
+package primitive
+
+import (
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// The .prim format is a compact encoding of a Result for placeholders.
+// It starts with the version, the flags, the bits of each coordinate and
+// the width, height and number of shapes as uvarints, followed by a bit
+// stream, most significant bit first, of the background in RGB565, the
+// kind of all shapes if they are of one kind, the palette if the shapes
+// use 16 colors or fewer, and the alpha of all shapes if they share one.
+// Each shape is then its kind, its color as a palette index or in RGB565,
+// its alpha in 4 bits, its blend mode in 3 bits and its fields, each as
+// needed. Coordinates are quantized to a grid over the plane with a
+// margin of a quarter of its size on each side, sizes to its larger side
+// and angles to their period.
+const primVersion = 1
+
+const (
+	primOneKind = 1 << iota
+	primPalette
+	primOneAlpha
+	primBlends
+	primTransparent
+)
+
+// primKind returns the kind of the shape in the .prim format, or false if
+// it cannot be encoded. Constrained shapes are encoded as their shape and
+// radial lines as lines, which draw the same.
+func primKind(s shape.Shape) (int, bool) {
+	switch s := s.(type) {
+	case *shape.Triangle:
+		return 0, true
+	case *shape.Rectangle:
+		return 1, true
+	case *shape.RotatedRectangle:
+		return 2, true
+	case *shape.Ellipse:
+		return 3, true
+	case *shape.RotatedEllipse:
+		return 4, true
+	case *shape.Line:
+		return 5, true
+	case *shape.Quadratic:
+		return 6, true
+	case *shape.Cubic:
+		return 7, true
+	case *shape.Polygon:
+		return 8, s.Order >= 3 && s.Order < 3+16
+	case *shape.BrushStroke:
+		return 9, s.Order >= 3 && s.Order < 3+16
+	case *shape.RegularPolygon:
+		return 10, s.Sides >= 3 && s.Sides < 3+16
+	case *shape.Star:
+		return 11, s.Points >= 3 && s.Points < 3+16
+	}
+	return 0, false
+}
+
+// newPrimShape returns an empty shape of the kind.
+func newPrimShape(kind int) shape.Shape {
+	switch kind {
+	case 0:
+		return &shape.Triangle{}
+	case 1:
+		return &shape.Rectangle{}
+	case 2:
+		return &shape.RotatedRectangle{}
+	case 3:
+		return &shape.Ellipse{}
+	case 4:
+		return &shape.RotatedEllipse{}
+	case 5:
+		return &shape.Line{}
+	case 6:
+		return &shape.Quadratic{}
+	case 7:
+		return &shape.Cubic{}
+	case 8:
+		return &shape.Polygon{}
+	case 9:
+		return &shape.BrushStroke{}
+	case 10:
+		return &shape.RegularPolygon{}
+	case 11:
+		return &shape.Star{}
+	}
+	return nil
+}
+
+// primShape returns the shape as it is encoded, looking through
+// Constrained shapes and radial lines.
+func primShape(s shape.Shape) shape.Shape {
+	switch t := s.(type) {
+	case *shape.Constrained:
+		return primShape(t.Shape)
+	case *shape.RadialLine:
+		return &t.Line
+	}
+	return s
+}
+
+// primCoder writes the fields of shapes to a bit stream, or reads them
+// from one, so that a single function lays out each kind of shape for
+// both. Written fields are set to their quantized values.
+type primCoder struct {
+	reading bool
+	buf     []byte
+	n       int // bits written or read
+	err     error
+	w, h    float64
+	bits    int
+}
+
+// uint codes the low bits of v.
+func (c *primCoder) uint(v *int, bits int) {
+	if c.reading {
+		*v = 0
+	}
+	for i := bits - 1; i >= 0; i-- {
+		if c.reading {
+			if c.n/8 >= len(c.buf) {
+				c.err = errors.New("prim: unexpected end of data")
+				return
+			}
+			*v |= int(c.buf[c.n/8]>>uint(7-c.n%8)&1) << uint(i)
+		} else {
+			if c.n%8 == 0 {
+				c.buf = append(c.buf, 0)
+			}
+			c.buf[c.n/8] |= byte(*v>>uint(i)&1) << uint(7-c.n%8)
+		}
+		c.n++
+	}
+}
+
+// quantized codes v, from lo to hi, in the bits of the coder.
+func (c *primCoder) quantized(v *float64, lo, hi float64) {
+	m := float64(int(1)<<uint(c.bits) - 1)
+	q := int(math.Round(clamp((*v-lo)/(hi-lo), 0, 1) * m))
+	c.uint(&q, c.bits)
+	*v = lo + float64(q)/m*(hi-lo)
+}
+
+func (c *primCoder) point(x, y *float64) {
+	c.quantized(x, -c.w/4, c.w*5/4)
+	c.quantized(y, -c.h/4, c.h*5/4)
+}
+
+func (c *primCoder) size(v *float64) {
+	c.quantized(v, 0, math.Max(c.w, c.h))
+}
+
+// width codes the width of a stroke, which is a few units at most.
+func (c *primCoder) width(v *float64) {
+	c.quantized(v, 0, 16)
+}
+
+// angle codes an angle in degrees of a shape which looks the same when
+// turned by the period.
+func (c *primCoder) angle(v *float64, period float64) {
+	n := float64(int(1) << uint(c.bits))
+	q := int(math.Round(*v/period*n)) & (int(n) - 1)
+	if q < 0 {
+		q += int(n)
+	}
+	c.uint(&q, c.bits)
+	*v = float64(q) / n * period
+}
+
+// integer codes an integer field with the function for floats.
+func (c *primCoder) integer(v *int, f func(*float64)) {
+	x := float64(*v)
+	f(&x)
+	*v = int(math.Round(x))
+}
+
+func (c *primCoder) integerPoint(x, y *int) {
+	fx, fy := float64(*x), float64(*y)
+	c.point(&fx, &fy)
+	*x, *y = int(math.Round(fx)), int(math.Round(fy))
+}
+
+// count codes a number of points from 3 to 18.
+func (c *primCoder) count(v *int) {
+	n := *v - 3
+	c.uint(&n, 4)
+	*v = n + 3
+}
+
+// points codes the first n points, making room for them when reading.
+func (c *primCoder) points(xs, ys []float64, n int) ([]float64, []float64) {
+	if c.reading {
+		xs, ys = make([]float64, n), make([]float64, n)
+	}
+	for i := 0; i < n; i++ {
+		c.point(&xs[i], &ys[i])
+	}
+	return xs, ys
+}
+
+// shape codes the fields of the shape which it draws with.
+func (c *primCoder) shape(s shape.Shape) {
+	switch s := s.(type) {
+	case *shape.Triangle:
+		c.point(&s.X1, &s.Y1)
+		c.point(&s.X2, &s.Y2)
+		c.point(&s.X3, &s.Y3)
+	case *shape.Rectangle:
+		c.integerPoint(&s.X1, &s.Y1)
+		c.integerPoint(&s.X2, &s.Y2)
+	case *shape.RotatedRectangle:
+		c.integerPoint(&s.X, &s.Y)
+		c.integer(&s.Sx, c.size)
+		c.integer(&s.Sy, c.size)
+		c.integer(&s.Angle, func(v *float64) { c.angle(v, 180) })
+	case *shape.Ellipse:
+		c.integerPoint(&s.X, &s.Y)
+		c.integer(&s.Rx, c.size)
+		c.integer(&s.Ry, c.size)
+	case *shape.RotatedEllipse:
+		c.point(&s.X, &s.Y)
+		c.size(&s.Rx)
+		c.size(&s.Ry)
+		c.angle(&s.Angle, 180)
+	case *shape.Line:
+		c.point(&s.X1, &s.Y1)
+		c.point(&s.X2, &s.Y2)
+		c.width(&s.Width)
+	case *shape.Quadratic:
+		c.point(&s.X1, &s.Y1)
+		c.point(&s.X2, &s.Y2)
+		c.point(&s.X3, &s.Y3)
+		c.width(&s.Width)
+	case *shape.Cubic:
+		c.point(&s.X1, &s.Y1)
+		c.point(&s.X2, &s.Y2)
+		c.point(&s.X3, &s.Y3)
+		c.point(&s.X4, &s.Y4)
+		c.width(&s.Width)
+	case *shape.Polygon:
+		c.count(&s.Order)
+		s.X, s.Y = c.points(s.X, s.Y, s.Order)
+	case *shape.BrushStroke:
+		c.count(&s.Order)
+		s.X, s.Y = c.points(s.X, s.Y, s.Order)
+		c.width(&s.StartWidth)
+		c.width(&s.MidWidth)
+		c.width(&s.EndWidth)
+	case *shape.RegularPolygon:
+		c.count(&s.Sides)
+		c.point(&s.X, &s.Y)
+		c.size(&s.Radius)
+		c.angle(&s.Angle, 360/float64(s.Sides))
+	case *shape.Star:
+		c.count(&s.Points)
+		c.point(&s.X, &s.Y)
+		c.size(&s.Outer)
+		c.size(&s.Inner)
+		c.angle(&s.Angle, 360/float64(s.Points))
+	}
+}
+
+// rgb565 codes the color in 16 bits.
+func (c *primCoder) rgb565(color *Color) {
+	v := color.R>>3<<11 | color.G>>2<<5 | color.B>>3
+	c.uint(&v, 16)
+	r, g, b := v>>11&31, v>>5&63, v&31
+	color.R, color.G, color.B = r<<3|r>>2, g<<2|g>>4, b<<3|b>>2
+}
+
+// primBits returns the number of bits for an index of n values.
+func primBits(n int) int {
+	bits := 0
+	for 1<<uint(bits) < n {
+		bits++
+	}
+	return bits
+}
+
+// Prim returns the result in the .prim format, with coordinates quantized
+// to the bits. Sprites, shapes with too many points and the gradient of
+// the background, which cannot be encoded, are left out with a warning.
+func (result *Result) Prim(bits int) []byte {
+	var shapes []ResultShape
+	skipped := 0
+	for _, s := range result.Shapes {
+		if _, ok := primKind(primShape(s.Shape)); !ok {
+			skipped++
+			continue
+		}
+		shapes = append(shapes, ResultShape{primShape(s.Shape).Copy(), s.Color, s.Blend})
+	}
+	if skipped > 0 {
+		warn("prim: %d shapes which cannot be encoded were left out\n", skipped)
+	}
+	if result.Gradient != nil {
+		warn("prim: the background gradient was stored as the background color\n")
+	}
+
+	flags := primOneKind | primOneAlpha
+	var colors []Color
+	index := make(map[Color]int)
+	for _, s := range shapes {
+		kind, _ := primKind(s.Shape)
+		first, _ := primKind(shapes[0].Shape)
+		if kind != first {
+			flags &^= primOneKind
+		}
+		if s.Color.A != shapes[0].Color.A {
+			flags &^= primOneAlpha
+		}
+		if s.Blend != BlendNormal {
+			flags |= primBlends
+		}
+		rgb := Color{s.Color.R, s.Color.G, s.Color.B, 255}
+		if _, ok := index[rgb]; !ok {
+			index[rgb] = len(colors)
+			colors = append(colors, rgb)
+		}
+	}
+	if len(colors) > 0 && len(colors) <= 16 {
+		flags |= primPalette
+	}
+	bg := result.Background
+	if bg.A == 0 {
+		flags |= primTransparent
+	}
+
+	header := []byte{primVersion, byte(flags), byte(bits)}
+	var varint [binary.MaxVarintLen64]byte
+	for _, v := range []int{result.Width, result.Height, len(shapes)} {
+		header = append(header, varint[:binary.PutUvarint(varint[:], uint64(v))]...)
+	}
+	c := &primCoder{w: float64(result.Width), h: float64(result.Height), bits: bits}
+	if flags&primTransparent == 0 {
+		c.rgb565(&bg)
+	}
+	if len(shapes) > 0 && flags&primOneKind != 0 {
+		kind, _ := primKind(shapes[0].Shape)
+		c.uint(&kind, 4)
+	}
+	if flags&primPalette != 0 {
+		n := len(colors) - 1
+		c.uint(&n, 4)
+		for _, color := range colors {
+			for _, v := range []int{color.R, color.G, color.B} {
+				c.uint(&v, 8)
+			}
+		}
+	}
+	if len(shapes) > 0 && flags&primOneAlpha != 0 {
+		c.uint(&shapes[0].Color.A, 8)
+	}
+	for _, s := range shapes {
+		if flags&primOneKind == 0 {
+			kind, _ := primKind(s.Shape)
+			c.uint(&kind, 4)
+		}
+		if flags&primPalette != 0 {
+			i := index[Color{s.Color.R, s.Color.G, s.Color.B, 255}]
+			c.uint(&i, primBits(len(colors)))
+		} else {
+			c.rgb565(&s.Color)
+		}
+		if flags&primOneAlpha == 0 {
+			a := (s.Color.A + 8) / 17
+			c.uint(&a, 4)
+		}
+		if flags&primBlends != 0 {
+			b := int(s.Blend)
+			c.uint(&b, 3)
+		}
+		c.shape(s.Shape)
+	}
+	return append(header, c.buf...)
+}
+
+// Prim returns the model in the .prim format. Its shapes are drawn
+// without the copies of symmetry or tiling, as in JSON output.
+func (model *Model) Prim(bits int) []byte {
+	return model.result().Prim(bits)
+}
+
+// DecodePrim reads a Result in the .prim format.
+func DecodePrim(data []byte) (*Result, error) {
+	if len(data) < 3 || data[0] != primVersion {
+		return nil, errors.New("prim: not a .prim file of a known version")
+	}
+	flags, bits := int(data[1]), int(data[2])
+	if bits < 1 || bits > 16 {
+		return nil, errors.New("prim: invalid number of bits")
+	}
+	data = data[3:]
+	var values [3]int
+	for i := range values {
+		v, n := binary.Uvarint(data)
+		if n <= 0 || v > 1<<20 {
+			return nil, errors.New("prim: invalid header")
+		}
+		values[i] = int(v)
+		data = data[n:]
+	}
+	result := &Result{Width: values[0], Height: values[1]}
+	count := values[2]
+	c := &primCoder{reading: true, buf: data, w: float64(result.Width), h: float64(result.Height), bits: bits}
+	if flags&primTransparent == 0 {
+		c.rgb565(&result.Background)
+		result.Background.A = 255
+	}
+	kind, alpha := 0, 0
+	if count > 0 && flags&primOneKind != 0 {
+		c.uint(&kind, 4)
+	}
+	var colors []Color
+	if flags&primPalette != 0 {
+		n := 0
+		c.uint(&n, 4)
+		colors = make([]Color, n+1)
+		for i := range colors {
+			c.uint(&colors[i].R, 8)
+			c.uint(&colors[i].G, 8)
+			c.uint(&colors[i].B, 8)
+		}
+	}
+	if count > 0 && flags&primOneAlpha != 0 {
+		c.uint(&alpha, 8)
+	}
+	for i := 0; i < count && c.err == nil; i++ {
+		s := ResultShape{}
+		if flags&primOneKind == 0 {
+			c.uint(&kind, 4)
+		}
+		if flags&primPalette != 0 {
+			j := 0
+			c.uint(&j, primBits(len(colors)))
+			if j >= len(colors) {
+				return nil, errors.New("prim: invalid color")
+			}
+			s.Color = colors[j]
+		} else {
+			c.rgb565(&s.Color)
+		}
+		s.Color.A = alpha
+		if flags&primOneAlpha == 0 {
+			c.uint(&s.Color.A, 4)
+			s.Color.A *= 17
+		}
+		if flags&primBlends != 0 {
+			b := 0
+			c.uint(&b, 3)
+			if b > int(BlendDifference) {
+				return nil, errors.New("prim: invalid blend mode")
+			}
+			s.Blend = BlendMode(b)
+		}
+		if s.Shape = newPrimShape(kind); s.Shape == nil {
+			return nil, errors.New("prim: invalid shape")
+		}
+		c.shape(s.Shape)
+		result.Shapes = append(result.Shapes, s)
+	}
+	if c.err != nil {
+		return nil, c.err
+	}
+	return result, nil
+}
+
+// Render draws the result at the size with the Draw methods of its shapes,
+// as the model draws them, without the copies of symmetry or tiling.
+func (result *Result) Render(width, height int) image.Image {
+	scale := float64(width) / float64(result.Width)
+	newContext := func() *gg.Context {
+		dc := gg.NewContext(width, height)
+		dc.Scale(scale, float64(height)/float64(result.Height))
+		dc.Translate(0.5, 0.5)
+		return dc
+	}
+	dc := newContext()
+	dc.SetColor(result.Background.NRGBA())
+	dc.Clear()
+	if result.Gradient != nil {
+		draw.Draw(dc.Image().(*image.RGBA), dc.Image().Bounds(),
+			result.Gradient.image(width, height, scale, 0.5), image.ZP, draw.Src)
+	}
+	var layer *gg.Context
+	for _, s := range result.Shapes {
+		c := s.Color
+		if s.Blend == BlendNormal {
+			dc.SetRGBA255(c.R, c.G, c.B, c.A)
+			s.Shape.Draw(dc, scale)
+			continue
+		}
+		if layer == nil {
+			layer = newContext()
+		}
+		layer.SetRGBA(0, 0, 0, 0)
+		layer.Clear()
+		layer.SetRGBA255(c.R, c.G, c.B, c.A)
+		s.Shape.Draw(layer, scale)
+		blendImage(dc.Image().(*image.RGBA), layer.Image().(*image.RGBA), c, s.Blend)
+	}
+	return dc.Image()
+}
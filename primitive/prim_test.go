@@ -0,0 +1,246 @@
+package primitive
+
+import (
+	"bytes"
+	"math"
+	"math/rand"
+	"reflect"
+	"testing"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// primShapes returns a shape of each kind of the .prim format on a 64 by
+// 48 plane.
+func primShapes() []shape.Shape {
+	return []shape.Shape{
+		&shape.Triangle{X1: 3.2, Y1: 4.7, X2: 50.1, Y2: 9.9, X3: 20.5, Y3: 40.3},
+		&shape.Rectangle{X1: 5, Y1: 6, X2: 30, Y2: 21},
+		&shape.RotatedRectangle{X: 32, Y: 24, Sx: 12, Sy: 5, Angle: 37},
+		&shape.Ellipse{X: 10, Y: 12, Rx: 7, Ry: 3},
+		&shape.RotatedEllipse{X: 40.5, Y: 20.25, Rx: 9.5, Ry: 2.75, Angle: 123.4},
+		&shape.Line{X1: -4.5, Y1: 2, X2: 60.25, Y2: 47, Width: 1.5},
+		&shape.Quadratic{X1: 1, Y1: 2, X2: 30, Y2: 45.5, X3: 62, Y3: 3, Width: 0.75},
+		&shape.Cubic{X1: 1, Y1: 40, X2: 20, Y2: -5, X3: 44, Y3: 50, X4: 63, Y4: 8, Width: 2.5},
+		&shape.Polygon{Order: 5, X: []float64{10, 20, 25, 15, 5}, Y: []float64{5, 8, 20, 30, 18}},
+		&shape.BrushStroke{Order: 3, X: []float64{10, 30, 50}, Y: []float64{40, 20, 35},
+			StartWidth: 1, MidWidth: 4.5, EndWidth: 0.5},
+		&shape.RegularPolygon{X: 22, Y: 30, Radius: 8.5, Angle: 10, Sides: 6},
+		&shape.Star{X: 48, Y: 12, Outer: 9, Inner: 4, Angle: 20, Points: 5},
+	}
+}
+
+// primResult returns a result of the shapes with the colors.
+func primResult(shapes []shape.Shape, colors []Color) *Result {
+	result := &Result{Width: 64, Height: 48, Background: Color{200, 180, 90, 255}}
+	for i, s := range shapes {
+		result.Shapes = append(result.Shapes, ResultShape{s, colors[i%len(colors)], BlendNormal})
+	}
+	return result
+}
+
+// decodePrim decodes the data, failing the test if it panics.
+func decodePrim(t *testing.T, data []byte) (result *Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.Fatalf("DecodePrim(%x) panicked: %v", data, r)
+		}
+	}()
+	return DecodePrim(data)
+}
+
+// roundTrip encodes the result, decodes it and checks that the decoded
+// result encodes to the same data, returning it with the data.
+func roundTrip(t *testing.T, result *Result, bits int) (*Result, []byte) {
+	data := result.Prim(bits)
+	decoded, err := decodePrim(t, data)
+	if err != nil {
+		t.Fatalf("DecodePrim: %v", err)
+	}
+	if decoded.Width != result.Width || decoded.Height != result.Height {
+		t.Fatalf("size %dx%d, want %dx%d", decoded.Width, decoded.Height, result.Width, result.Height)
+	}
+	if len(decoded.Shapes) != len(result.Shapes) {
+		t.Fatalf("%d shapes, want %d", len(decoded.Shapes), len(result.Shapes))
+	}
+	if again := decoded.Prim(bits); !bytes.Equal(again, data) {
+		t.Fatalf("decoded result encodes to %x, want %x", again, data)
+	}
+	return decoded, data
+}
+
+// primFields returns the float fields of the shape, flattening slices.
+func primFields(s shape.Shape) []float64 {
+	var fields []float64
+	v := reflect.ValueOf(s).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		switch f := v.Field(i); f.Kind() {
+		case reflect.Float64:
+			fields = append(fields, f.Float())
+		case reflect.Int:
+			fields = append(fields, float64(f.Int()))
+		case reflect.Slice:
+			for j := 0; j < f.Len(); j++ {
+				fields = append(fields, f.Index(j).Float())
+			}
+		}
+	}
+	return fields
+}
+
+func TestPrimRoundTripShapes(t *testing.T) {
+	shapes := primShapes()
+	for _, bits := range []int{6, 10, 16} {
+		// with a step of 1.5 times the plane over the levels, the
+		// quantized fields are within half a step, and integer fields
+		// within half a unit more
+		tolerance := 96/float64(int(1)<<uint(bits)-1)/2 + 0.5 + 1e-9
+		for _, s := range shapes {
+			result := primResult([]shape.Shape{s}, []Color{{255, 0, 0, 128}})
+			decoded, _ := roundTrip(t, result, bits)
+			d := decoded.Shapes[0].Shape
+			if reflect.TypeOf(d) != reflect.TypeOf(s) {
+				t.Fatalf("bits %d: decoded %T, want %T", bits, d, s)
+			}
+			want, got := primFields(s), primFields(d)
+			for i := range want {
+				if e := math.Abs(got[i] - want[i]); e > tolerance && !isAngleField(s, i, e) {
+					t.Errorf("bits %d: %T field %d is %v, want %v", bits, s, i, got[i], want[i])
+				}
+			}
+		}
+	}
+}
+
+// isAngleField reports whether the difference e of the field of the shape
+// is in its angle, which is quantized to its own period.
+func isAngleField(s shape.Shape, i int, e float64) bool {
+	switch s.(type) {
+	case *shape.RotatedRectangle, *shape.RotatedEllipse, *shape.RegularPolygon, *shape.Star:
+		return reflect.ValueOf(s).Elem().Type().Field(i).Name == "Angle" && e <= 3
+	}
+	return false
+}
+
+func TestPrimPalette(t *testing.T) {
+	colors := []Color{{255, 0, 0, 128}, {0, 255, 0, 128}, {12, 34, 56, 128}}
+	result := primResult(primShapes(), colors)
+	decoded, data := roundTrip(t, result, 8)
+	if data[1]&primPalette == 0 {
+		t.Fatalf("flags %x, want a palette", data[1])
+	}
+	for i, s := range decoded.Shapes {
+		if s.Color != result.Shapes[i].Color {
+			t.Errorf("shape %d: color %v, want %v", i, s.Color, result.Shapes[i].Color)
+		}
+	}
+}
+
+func TestPrimRGB565(t *testing.T) {
+	var colors []Color
+	for i := 0; i < 24; i++ {
+		colors = append(colors, Color{i * 10, 255 - i*7, i * 3, 200})
+	}
+	var shapes []shape.Shape
+	for i := range colors {
+		shapes = append(shapes, &shape.Triangle{X1: float64(i), Y1: 1, X2: 30, Y2: 40, X3: 50, Y3: 5})
+	}
+	result := primResult(shapes, colors)
+	decoded, data := roundTrip(t, result, 8)
+	if data[1]&primPalette != 0 {
+		t.Fatalf("flags %x, want no palette for %d colors", data[1], len(colors))
+	}
+	for i, s := range decoded.Shapes {
+		want := result.Shapes[i].Color
+		// the low bits are dropped, so 5 bits are within 7 and 6 within 3
+		if absInt(s.Color.R-want.R) > 7 || absInt(s.Color.G-want.G) > 3 || absInt(s.Color.B-want.B) > 7 || s.Color.A != want.A {
+			t.Errorf("shape %d: color %v, want about %v", i, s.Color, want)
+		}
+	}
+}
+
+func TestPrimFlags(t *testing.T) {
+	triangles := []shape.Shape{primShapes()[0], primShapes()[0].Copy()}
+	blended := primResult(primShapes(), []Color{{1, 2, 3, 100}})
+	blended.Shapes[3].Blend = BlendMultiply
+	blended.Shapes[7].Blend = BlendDifference
+	transparent := primResult(triangles, []Color{{1, 2, 3, 100}})
+	transparent.Background = Color{}
+	tests := []struct {
+		name    string
+		result  *Result
+		set     int
+		cleared int
+	}{
+		{"one kind and alpha", primResult(triangles, []Color{{1, 2, 3, 100}}),
+			primOneKind | primOneAlpha, primBlends | primTransparent},
+		{"mixed kinds", primResult(primShapes(), []Color{{1, 2, 3, 100}}),
+			primOneAlpha, primOneKind},
+		{"varied alpha", primResult(triangles, []Color{{1, 2, 3, 100}, {1, 2, 3, 200}}),
+			primOneKind, primOneAlpha},
+		{"blends", blended, primBlends, 0},
+		{"transparent", transparent, primTransparent, 0},
+	}
+
+	for _, test := range tests {
+		decoded, data := roundTrip(t, test.result, 6)
+		flags := int(data[1])
+		if flags&test.set != test.set || flags&test.cleared != 0 {
+			t.Errorf("%s: flags %x, want %x set and %x clear", test.name, flags, test.set, test.cleared)
+		}
+		for i, s := range decoded.Shapes {
+			want := test.result.Shapes[i]
+			if s.Blend != want.Blend {
+				t.Errorf("%s: shape %d blend %v, want %v", test.name, i, s.Blend, want.Blend)
+			}
+			// a shared alpha is exact, and others are rounded to 4 bits
+			if absInt(s.Color.A-want.Color.A) > 8 || flags&primOneAlpha != 0 && s.Color.A != want.Color.A {
+				t.Errorf("%s: shape %d alpha %d, want %d", test.name, i, s.Color.A, want.Color.A)
+			}
+		}
+		if (decoded.Background.A == 0) != (test.result.Background.A == 0) {
+			t.Errorf("%s: background %v, want %v", test.name, decoded.Background, test.result.Background)
+		}
+	}
+}
+
+func TestPrimTruncated(t *testing.T) {
+	data := primResult(primShapes(), []Color{{255, 0, 0, 128}, {0, 0, 255, 64}}).Prim(6)
+	for n := 0; n < len(data); n++ {
+		if _, err := decodePrim(t, data[:n]); err == nil {
+			t.Errorf("DecodePrim of %d of %d bytes: no error", n, len(data))
+		}
+	}
+}
+
+func TestPrimGarbage(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	valid := primResult(primShapes(), []Color{{255, 0, 0, 128}}).Prim(6)
+	for i := 0; i < 2000; i++ {
+		data := make([]byte, rnd.Intn(64))
+		rnd.Read(data)
+		if i%2 == 0 && len(data) > 0 {
+			// past the version check, to reach the header and shapes
+			data[0] = primVersion
+		}
+		decodePrim(t, data)
+	}
+	// every bit of valid data flipped in turn
+	for i := 0; i < len(valid)*8; i++ {
+		data := append([]byte(nil), valid...)
+		data[i/8] ^= 1 << uint(i%8)
+		decodePrim(t, data)
+	}
+	for _, data := range [][]byte{nil, {primVersion}, {2, 0, 6, 1, 1, 0}, {primVersion, 0, 0, 1, 1, 0}, {primVersion, 0, 17, 1, 1, 0}} {
+		if _, err := decodePrim(t, data); err == nil {
+			t.Errorf("DecodePrim(%x): no error", data)
+		}
+	}
+}
+
+func absInt(x int) int {
+	if x < 0 {
+		return -x
+	}
+	return x
+}
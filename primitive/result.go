@@ -0,0 +1,149 @@
+package primitive
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// Result is a model saved as JSON by a .json output: its shapes, with the
+// size of the plane they were fitted on, so that a later run can start
+// from it with -init.
+type Result struct {
+	Width, Height int
+	Background    Color
+	Gradient      *Gradient `json:",omitempty"`
+	Shapes        []ResultShape
+}
+
+// ResultShape is a shape of a Result, as drawn.
+type ResultShape struct {
+	Shape shape.Shape
+	Color Color
+	Blend BlendMode
+}
+
+type resultShapeForJson struct {
+	Shape json.RawMessage
+	Color Color
+	Blend string `json:",omitempty"`
+}
+
+func (s ResultShape) MarshalJSON() ([]byte, error) {
+	data, err := shape.MarshalShape(s.Shape)
+	if err != nil {
+		return nil, err
+	}
+	x := resultShapeForJson{Shape: data, Color: s.Color}
+	if s.Blend != BlendNormal {
+		x.Blend = s.Blend.String()
+	}
+	return json.Marshal(x)
+}
+
+func (s *ResultShape) UnmarshalJSON(data []byte) error {
+	x := resultShapeForJson{}
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	sh, err := shape.UnmarshalShape(x.Shape)
+	if err != nil {
+		return err
+	}
+	blend, err := ParseBlendMode(x.Blend)
+	if err != nil {
+		return err
+	}
+	s.Shape, s.Color, s.Blend = sh, x.Color, blend
+	return nil
+}
+
+// result returns the shapes of the model as a Result.
+func (model *Model) result() *Result {
+	result := &Result{Width: model.RC.W, Height: model.RC.H, Background: model.Background, Gradient: model.gradient}
+	for _, s := range model.Shapes {
+		result.Shapes = append(result.Shapes, ResultShape{s.Shape, s.Color, s.Blend})
+	}
+	return result
+}
+
+// JSON returns the model as a Result.
+func (model *Model) JSON() string {
+	data, err := json.MarshalIndent(model.result(), "", "  ")
+	if err != nil {
+		panic(err)
+	}
+	return string(data) + "\n"
+}
+
+// LoadResult reads a Result saved by a .json, .prim, .svg or .svgz output.
+func LoadResult(path string) (*Result, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var result *Result
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".prim":
+		result, err = DecodePrim(data)
+	case ".svgz":
+		data, err = gunzip(data)
+		if err == nil {
+			result, err = ParseSVG(data)
+		}
+	case ".svg":
+		result, err = ParseSVG(data)
+	default:
+		result = &Result{}
+		err = json.Unmarshal(data, result)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return result, nil
+}
+
+// SetInit starts the model from the image, which is stretched to cover
+// the canvas, rather than from the background color. It must be called
+// before Init.
+func (model *Model) SetInit(im image.Image) {
+	model.initImage = im
+	model.Current = resizeRGBA(im, model.RC.W, model.RC.H)
+	model.Score = differenceFull(model.Target, model.Current)
+	model.Context = model.newContext()
+}
+
+// svgImage returns an SVG image element covering the w by h canvas with
+// the image embedded as a PNG.
+func svgImage(im image.Image, w, h int) string {
+	var buf bytes.Buffer
+	png.Encode(&buf, im)
+	return fmt.Sprintf(
+		"<image x=\"0\" y=\"0\" width=\"%d\" height=\"%d\" preserveAspectRatio=\"none\" href=\"data:image/png;base64,%s\" />",
+		w, h, base64.StdEncoding.EncodeToString(buf.Bytes()))
+}
+
+// AddResult draws the shapes of the result onto the model in their saved
+// colors, as if they had been added by this run. They are drawn with the
+// symmetry and tiling of this model. It must be called after Init.
+func (model *Model) AddResult(result *Result) error {
+	if result.Width != model.RC.W || result.Height != model.RC.H {
+		return fmt.Errorf("result was fitted at %dx%d, not %dx%d; set -r to match",
+			result.Width, result.Height, model.RC.W, model.RC.H)
+	}
+	for _, s := range result.Shapes {
+		if model.Linear && s.Blend != BlendNormal {
+			return fmt.Errorf("blend modes are not supported with linear")
+		}
+		model.add(s.Shape, s.Color, s.Blend, model.rasterize(s.Shape))
+	}
+	return nil
+}
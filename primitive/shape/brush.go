@@ -0,0 +1,261 @@
+package shape
+
+import (
+	"math"
+
+	"github.com/fogleman/gg"
+)
+
+// brushSegments is the number of samples taken along each spline segment
+// when computing the outline of a BrushStroke.
+const brushSegments = 8
+
+// brushTries is the number of mutations tried, after the rollbacks, before
+// a BrushStroke gives up and keeps its previous state.
+const brushTries = 1000
+
+// BrushStroke represents a tapered stroke along a Catmull-Rom spline which
+// passes through Order control points. The width of the stroke follows a
+// smooth profile through StartWidth, MidWidth and EndWidth, so the stroke
+// is rasterized as a filled outline rather than as a fixed-width line.
+type BrushStroke struct {
+	Order      int // 3 to 6 control points; 0 picks a random order
+	X, Y       []float64
+	StartWidth float64
+	MidWidth   float64
+	EndWidth   float64
+	MinWidth   float64
+	MaxWidth   float64
+}
+
+func NewBrushStroke() *BrushStroke {
+	b := &BrushStroke{}
+	b.MaxWidth = 8
+	return b
+}
+
+func (b *BrushStroke) Init(plane *Plane) {
+	rnd := plane.Rnd
+	if b.Order < 3 || b.Order > 6 {
+		b.Order = 3 + rnd.Intn(4)
+	}
+	if b.MaxWidth <= 0 {
+		b.MaxWidth = 8
+	}
+	b.X = make([]float64, b.Order)
+	b.Y = make([]float64, b.Order)
+	b.X[0] = randomW(plane)
+	b.Y[0] = randomH(plane)
+	for i := 1; i < b.Order; i++ {
+		b.X[i] = b.X[i-1] + rnd.Float64()*40 - 20
+		b.Y[i] = b.Y[i-1] + rnd.Float64()*40 - 20
+	}
+	b.StartWidth = b.randomWidth(plane)
+	b.MidWidth = b.randomWidth(plane)
+	b.EndWidth = b.randomWidth(plane)
+	b.mutateImpl(plane, 1.0, 2, ActionAny)
+}
+
+func (b *BrushStroke) randomWidth(plane *Plane) float64 {
+	return b.MinWidth + plane.Rnd.Float64()*(b.MaxWidth-b.MinWidth)
+}
+
+func (b *BrushStroke) Draw(dc *gg.Context, scale float64) {
+	xs, ys := b.outline()
+	drawPoints(dc, xs, ys)
+}
+
+func (b *BrushStroke) SVG(attrs string) string {
+	xs, ys := b.outline()
+	return svgPolygon(attrs, xs, ys)
+}
+
+func (b *BrushStroke) Copy() Shape {
+	a := *b
+	a.X = make([]float64, len(b.X))
+	a.Y = make([]float64, len(b.Y))
+	copy(a.X, b.X)
+	copy(a.Y, b.Y)
+	return &a
+}
+
+func (b *BrushStroke) Mutate(plane *Plane, temp float64) {
+	b.mutateImpl(plane, temp, 10, ActionAny)
+}
+
+func (b *BrushStroke) mutateImpl(plane *Plane, temp float64, rollback int, actions ActionType) {
+	if actions == ActionNone {
+		return
+	}
+
+	const R = math.Pi / 4.0
+	const m = 16
+	w := float64(plane.W - 1 + m)
+	h := float64(plane.H - 1 + m)
+	rnd := plane.Rnd
+	scale := 16 * temp
+	save := b.Copy().(*BrushStroke)
+	for i := 0; ; i++ {
+		switch rnd.Intn(6) {
+		case 0: // Move a control point
+			if (actions & ActionMutate) == 0 {
+				continue
+			}
+			i := rnd.Intn(b.Order)
+			b.X[i] = clamp(b.X[i]+rnd.NormFloat64()*scale, -m, w)
+			b.Y[i] = clamp(b.Y[i]+rnd.NormFloat64()*scale, -m, h)
+		case 1: // Width profile
+			if (actions & ActionScale) == 0 {
+				continue
+			}
+			d := rnd.NormFloat64() * temp * 2
+			switch rnd.Intn(3) {
+			case 0:
+				b.StartWidth = clamp(b.StartWidth+d, b.MinWidth, b.MaxWidth)
+			case 1:
+				b.MidWidth = clamp(b.MidWidth+d, b.MinWidth, b.MaxWidth)
+			case 2:
+				b.EndWidth = clamp(b.EndWidth+d, b.MinWidth, b.MaxWidth)
+			}
+		case 2: // Scale all widths
+			if (actions & ActionScale) == 0 {
+				continue
+			}
+			f := math.Exp(rnd.NormFloat64() * temp * 0.25)
+			b.StartWidth = clamp(b.StartWidth*f, b.MinWidth, b.MaxWidth)
+			b.MidWidth = clamp(b.MidWidth*f, b.MinWidth, b.MaxWidth)
+			b.EndWidth = clamp(b.EndWidth*f, b.MinWidth, b.MaxWidth)
+		case 3: // Translate
+			if (actions & ActionTranslate) == 0 {
+				continue
+			}
+			x := rnd.NormFloat64() * scale
+			y := rnd.NormFloat64() * scale
+			for i := range b.X {
+				b.X[i] = clamp(b.X[i]+x, -m, w)
+				b.Y[i] = clamp(b.Y[i]+y, -m, h)
+			}
+		case 4: // Rotate
+			if (actions & ActionRotate) == 0 {
+				continue
+			}
+			cx, cy := 0.0, 0.0
+			for i := range b.X {
+				cx += b.X[i]
+				cy += b.Y[i]
+			}
+			cx /= float64(b.Order)
+			cy /= float64(b.Order)
+			theta := rnd.NormFloat64() * temp * R
+			cos := math.Cos(theta)
+			sin := math.Sin(theta)
+			for i := range b.X {
+				x, y := rotateAbout(b.X[i], b.Y[i], cx, cy, cos, sin)
+				b.X[i] = clamp(x, -m, w)
+				b.Y[i] = clamp(y, -m, h)
+			}
+		case 5: // Swap the ends of the width profile
+			if (actions & ActionMutate) == 0 {
+				continue
+			}
+			b.StartWidth, b.EndWidth = b.EndWidth, b.StartWidth
+		}
+		if b.Valid() {
+			break
+		}
+		if rollback > 0 {
+			b.restore(save)
+			rollback -= 1
+		} else if i >= brushTries {
+			// no valid stroke is in reach, so keep the last one
+			b.restore(save)
+			break
+		}
+	}
+}
+
+// restore sets the control points and widths of b back to those of save.
+func (b *BrushStroke) restore(save *BrushStroke) {
+	copy(b.X, save.X)
+	copy(b.Y, save.Y)
+	b.StartWidth = save.StartWidth
+	b.MidWidth = save.MidWidth
+	b.EndWidth = save.EndWidth
+}
+
+func (b *BrushStroke) Valid() bool {
+	for i := 1; i < b.Order; i++ {
+		dx := b.X[i] - b.X[i-1]
+		dy := b.Y[i] - b.Y[i-1]
+		if dx*dx+dy*dy <= 1 {
+			return false
+		}
+	}
+	return math.Max(b.StartWidth, math.Max(b.MidWidth, b.EndWidth)) >= 0.5
+}
+
+// width returns the stroke width at t in [0, 1] along the stroke. It is the
+// quadratic through the start, middle and end widths.
+func (b *BrushStroke) width(t float64) float64 {
+	s, m, e := b.StartWidth, b.MidWidth, b.EndWidth
+	w := s*(1-t)*(1-2*t) + 4*m*t*(1-t) + e*t*(2*t-1)
+	return math.Max(w, 0)
+}
+
+// spline samples the Catmull-Rom spline through the control points.
+func (b *BrushStroke) spline() (xs, ys []float64) {
+	n := b.Order
+	xs = make([]float64, 0, (n-1)*brushSegments+1)
+	ys = make([]float64, 0, (n-1)*brushSegments+1)
+	for i := 0; i < n-1; i++ {
+		i0 := maxInt(i-1, 0)
+		i3 := minInt(i+2, n-1)
+		x0, y0 := b.X[i0], b.Y[i0]
+		x1, y1 := b.X[i], b.Y[i]
+		x2, y2 := b.X[i+1], b.Y[i+1]
+		x3, y3 := b.X[i3], b.Y[i3]
+		for j := 0; j < brushSegments; j++ {
+			t := float64(j) / brushSegments
+			xs = append(xs, catmullRom(x0, x1, x2, x3, t))
+			ys = append(ys, catmullRom(y0, y1, y2, y3, t))
+		}
+	}
+	xs = append(xs, b.X[n-1])
+	ys = append(ys, b.Y[n-1])
+	return
+}
+
+func catmullRom(p0, p1, p2, p3, t float64) float64 {
+	t2 := t * t
+	t3 := t2 * t
+	return 0.5 * (2*p1 + (p2-p0)*t + (2*p0-5*p1+4*p2-p3)*t2 + (3*p1-p0-3*p2+p3)*t3)
+}
+
+// outline returns the closed polygon enclosing the stroke: the left edge
+// from start to end followed by the right edge from end to start.
+func (b *BrushStroke) outline() (xs, ys []float64) {
+	sx, sy := b.spline()
+	n := len(sx)
+	xs = make([]float64, 2*n)
+	ys = make([]float64, 2*n)
+	for i := 0; i < n; i++ {
+		j0 := maxInt(i-1, 0)
+		j1 := minInt(i+1, n-1)
+		dx := sx[j1] - sx[j0]
+		dy := sy[j1] - sy[j0]
+		d := math.Sqrt(dx*dx + dy*dy)
+		if d > 0 {
+			dx /= d
+			dy /= d
+		}
+		r := b.width(float64(i)/float64(n-1)) / 2
+		xs[i], ys[i] = sx[i]-dy*r, sy[i]+dx*r
+		xs[2*n-1-i], ys[2*n-1-i] = sx[i]+dy*r, sy[i]-dx*r
+	}
+	return
+}
+
+func (b *BrushStroke) Rasterize(rc *RasterContext) []Scanline {
+	xs, ys := b.outline()
+	return fillPoints(rc, xs, ys)
+}
@@ -0,0 +1,346 @@
+package shape
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"sync"
+
+	"github.com/fogleman/gg"
+)
+
+// constraintTries is the number of mutations tried before a Constrained
+// shape gives up and keeps its previous state.
+const constraintTries = 100
+
+// Constrained wraps any Shape, and extends its Valid() with constraints on
+// the rasterized shape. Mutations which break a constraint are rolled back,
+// so only shapes satisfying all of the constraints are scored. For example,
+// rotated rectangles pointing toward a point:
+//
+//	{"Constrained":{"Shape":{"RotatedRectangle":{}},"Orientation":{"X":0.6,"Y":0.43}}}
+type Constrained struct {
+	Shape       Shape
+	Region      *RegionConstraint
+	Orientation *OrientationConstraint
+	Size        *SizeConstraint
+	Aspect      *AspectConstraint
+	plane       *Plane
+	mask        *regionMask
+}
+
+// RegionConstraint keeps the shape inside the area of the Mask image which
+// is both white and opaque, with the image stretched to cover the plane. At
+// most Outside of the shape's coverage, as a fraction, may fall outside the
+// region.
+type RegionConstraint struct {
+	Mask    string
+	Invert  bool
+	Outside float64
+	image   image.Image
+}
+
+// OrientationConstraint keeps the long axis of the shape pointing toward
+// the point X, Y, given as fractions of the plane, to within MaxAngle
+// degrees. Away points it away from the point instead. The direction of a
+// shape is taken from the narrow end of its long axis; shapes which are
+// symmetric along that axis, such as rectangles, satisfy both.
+type OrientationConstraint struct {
+	X, Y     float64
+	MaxAngle float64
+	Away     bool
+}
+
+// SizeConstraint limits the longer side of the shape's bounding box, in
+// plane pixels. A zero Max means no upper limit.
+type SizeConstraint struct {
+	Min, Max float64
+}
+
+// AspectConstraint limits the ratio of the length of the shape to its
+// width, measured along its principal axes. A zero Max means no limit.
+type AspectConstraint struct {
+	Min, Max float64
+}
+
+func NewConstrained(s Shape) *Constrained {
+	return &Constrained{Shape: s}
+}
+
+func (c *Constrained) Init(plane *Plane) {
+	c.plane = plane
+	if c.Region != nil {
+		c.mask = c.Region.load(plane.W, plane.H)
+	}
+	for i := 0; i < constraintTries; i++ {
+		c.Shape.Init(plane)
+		if c.Valid() {
+			return
+		}
+	}
+	vv("Constrained: no valid shape found for %v\n", c.Shape)
+}
+
+func (c *Constrained) Draw(dc *gg.Context, scale float64) {
+	c.Shape.Draw(dc, scale)
+}
+
+func (c *Constrained) SVG(attrs string) string {
+	return c.Shape.SVG(attrs)
+}
+
+func (c *Constrained) Copy() Shape {
+	a := *c
+	a.Shape = c.Shape.Copy()
+	return &a
+}
+
+func (c *Constrained) Mutate(plane *Plane, temp float64) {
+	save := c.Shape.Copy()
+	for i := 0; i < constraintTries; i++ {
+		c.Shape.Mutate(plane, temp)
+		if c.Valid() {
+			return
+		}
+		c.Shape = save.Copy()
+	}
+}
+
+func (c *Constrained) Valid() bool {
+	if !c.Shape.Valid() {
+		return false
+	}
+	if c.plane == nil {
+		return true
+	}
+	lines := c.Shape.Rasterize(c.plane.rasterContext())
+	if len(lines) == 0 {
+		return false
+	}
+	if c.Region != nil && !c.Region.valid(lines, c.mask) {
+		return false
+	}
+	if c.Size != nil && !c.Size.valid(lines) {
+		return false
+	}
+	if c.Orientation == nil && c.Aspect == nil {
+		return true
+	}
+	m := computeMoments(lines)
+	if c.Aspect != nil && !c.Aspect.valid(m) {
+		return false
+	}
+	if c.Orientation != nil && !c.Orientation.valid(m, c.plane) {
+		return false
+	}
+	return true
+}
+
+func (c *Constrained) Rasterize(rc *RasterContext) []Scanline {
+	return c.Shape.Rasterize(rc)
+}
+
+// SVGDefs passes through the definitions of the wrapped shape.
+func (c *Constrained) SVGDefs() []string {
+	if d, ok := c.Shape.(SVGDefiner); ok {
+		return d.SVGDefs()
+	}
+	return nil
+}
+
+// LoadMasks reads the mask images of the region constraints of the shapes
+// made by factory with load, so that a bad path is reported before any
+// shape is made.
+func LoadMasks(factory ShapeFactory, load func(path string) (image.Image, error)) error {
+	selected, ok := factory.(*SelectedShapes)
+	if !ok {
+		return nil
+	}
+	for _, s := range selected.Shapes {
+		if err := loadMasks(s, load); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// loadMasks reads the masks of s and of the constrained shapes it wraps.
+func loadMasks(s Shape, load func(path string) (image.Image, error)) error {
+	c, ok := s.(*Constrained)
+	if !ok {
+		return nil
+	}
+	if c.Region != nil {
+		im, err := load(c.Region.Mask)
+		if err != nil {
+			return err
+		}
+		c.Region.image = im
+	}
+	return loadMasks(c.Shape, load)
+}
+
+// regionMask is a mask image resampled to the size of a plane.
+type regionMask struct {
+	W, H    int
+	Allowed []bool
+}
+
+var (
+	regionMu    sync.Mutex
+	regionMasks = map[string]*regionMask{}
+)
+
+func (r *RegionConstraint) load(w, h int) *regionMask {
+	regionMu.Lock()
+	defer regionMu.Unlock()
+	key := fmt.Sprintf("%s@%dx%d", r.Mask, w, h)
+	if m, ok := regionMasks[key]; ok {
+		return m
+	}
+	im := r.image
+	if im == nil {
+		panic("RegionConstraint mask is not loaded; use LoadMasks")
+	}
+	b := im.Bounds()
+	m := &regionMask{w, h, make([]bool, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			cr, cg, cb, ca := im.At(b.Min.X+x*b.Dx()/w, b.Min.Y+y*b.Dy()/h).RGBA()
+			// premultiplied, so transparent pixels are black
+			m.Allowed[y*w+x] = (cr+cg+cb)/3 >= 0x8000 && ca >= 0x8000
+		}
+	}
+	regionMasks[key] = m
+	return m
+}
+
+func (r *RegionConstraint) valid(lines []Scanline, m *regionMask) bool {
+	var inside, total uint64
+	for _, line := range lines {
+		i := line.Y*m.W + line.X1
+		for x := line.X1; x <= line.X2; x++ {
+			if m.Allowed[i] != r.Invert {
+				inside += uint64(line.Alpha)
+			}
+			total += uint64(line.Alpha)
+			i++
+		}
+	}
+	return float64(total-inside) <= r.Outside*float64(total)
+}
+
+func (s *SizeConstraint) valid(lines []Scanline) bool {
+	x1, y1 := lines[0].X1, lines[0].Y
+	x2, y2 := lines[0].X2, lines[0].Y
+	for _, line := range lines {
+		x1 = minInt(x1, line.X1)
+		x2 = maxInt(x2, line.X2)
+		y1 = minInt(y1, line.Y)
+		y2 = maxInt(y2, line.Y)
+	}
+	size := float64(maxInt(x2-x1+1, y2-y1+1))
+	return size >= s.Min && (s.Max <= 0 || size <= s.Max)
+}
+
+func (a *AspectConstraint) valid(m moments) bool {
+	aspect := m.aspect()
+	return aspect >= a.Min && (a.Max <= 0 || aspect <= a.Max)
+}
+
+func (o *OrientationConstraint) valid(m moments, plane *Plane) bool {
+	if m.aspect() < 1.1 {
+		// round shapes have no orientation
+		return true
+	}
+	maxAngle := o.MaxAngle
+	if maxAngle <= 0 {
+		maxAngle = 15
+	}
+	dx := o.X*float64(plane.W) - m.X
+	dy := o.Y*float64(plane.H) - m.Y
+	ux, uy := math.Cos(m.Angle), math.Sin(m.Angle)
+	d := math.Sqrt(dx*dx + dy*dy)
+	if d < 1 {
+		return true
+	}
+	// the angle between the axis and the direction to the point, in [0, 90]
+	cos := math.Abs(ux*dx+uy*dy) / d
+	if degrees(math.Acos(math.Min(cos, 1))) > maxAngle {
+		return false
+	}
+	if math.Abs(m.Skew) < 0.1 {
+		return true
+	}
+	toward := (ux*dx+uy*dy)*m.Skew > 0
+	return toward != o.Away
+}
+
+// moments describes the distribution of coverage of a rasterized shape:
+// its centroid, the angle of its long axis and the variance along and
+// across that axis. Skew is positive when the narrow end of the shape lies
+// in the direction of Angle.
+type moments struct {
+	X, Y  float64
+	Angle float64
+	Major float64
+	Minor float64
+	Skew  float64
+}
+
+func computeMoments(lines []Scanline) moments {
+	var m0, mx, my float64
+	for _, line := range lines {
+		a := float64(line.Alpha)
+		n := float64(line.X2 - line.X1 + 1)
+		m0 += a * n
+		mx += a * n * float64(line.X1+line.X2) / 2
+		my += a * n * float64(line.Y)
+	}
+	var m moments
+	if m0 == 0 {
+		return m
+	}
+	m.X, m.Y = mx/m0, my/m0
+	var cxx, cyy, cxy float64
+	for _, line := range lines {
+		a := float64(line.Alpha)
+		dy := float64(line.Y) - m.Y
+		for x := line.X1; x <= line.X2; x++ {
+			dx := float64(x) - m.X
+			cxx += a * dx * dx
+			cyy += a * dy * dy
+			cxy += a * dx * dy
+		}
+	}
+	// each pixel is a unit square, not a point
+	cxx = cxx/m0 + 1.0/12
+	cyy = cyy/m0 + 1.0/12
+	cxy /= m0
+	m.Angle = math.Atan2(2*cxy, cxx-cyy) / 2
+	d := math.Sqrt((cxx-cyy)*(cxx-cyy)/4 + cxy*cxy)
+	m.Major = (cxx+cyy)/2 + d
+	m.Minor = (cxx+cyy)/2 - d
+	ux, uy := math.Cos(m.Angle), math.Sin(m.Angle)
+	var skew float64
+	for _, line := range lines {
+		a := float64(line.Alpha)
+		dy := float64(line.Y) - m.Y
+		for x := line.X1; x <= line.X2; x++ {
+			p := (float64(x)-m.X)*ux + dy*uy
+			skew += a * p * p * p
+		}
+	}
+	if m.Major > 0 {
+		m.Skew = skew / m0 / math.Pow(m.Major, 1.5)
+	}
+	return m
+}
+
+// aspect returns the ratio of the length to the width of the shape.
+func (m moments) aspect() float64 {
+	if m.Minor <= 0 {
+		return math.Inf(1)
+	}
+	return math.Sqrt(m.Major / m.Minor)
+}
@@ -3,7 +3,6 @@ package shape
 import (
 	"fmt"
 	"math"
-	"strings"
 
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/raster"
@@ -51,11 +50,9 @@ func (q *Cubic) Draw(dc *gg.Context, scale float64) {
 }
 
 func (q *Cubic) SVG(attrs string) string {
-	// TODO: this is a little silly
-	attrs = strings.Replace(attrs, "fill", "stroke", -1)
 	return fmt.Sprintf(
-		"<path %s fill=\"none\" d=\"M %f %f Q %f %f, %f %f, %f %f\" stroke-width=\"%f\" />",
-		attrs, q.X1, q.Y1, q.X2, q.Y2, q.X3, q.Y3, q.X4, q.Y4, q.Width)
+		"<path%s d=\"M%s C%s\" stroke-width=\"%s\" />",
+		svgAttrs(attrs), svgNums(q.X1, q.Y1), svgNums(q.X2, q.Y2, q.X3, q.Y3, q.X4, q.Y4), SVGNum(q.Width))
 }
 
 func (q *Cubic) Copy() Shape {
@@ -197,10 +194,10 @@ func (q *Cubic) arcLength() float64 {
 		a := mt2 * mt
 		b := mt2 * t * 3
 		c := mt * t2 * 3
-		d := t * t2
+		e := t * t2
 
-		nx := a*q.X1 + b*q.X2 + c*q.X3 + d*q.X4
-		ny := a*q.Y1 + b*q.Y2 + c*q.Y3 + d*q.Y4
+		nx := a*q.X1 + b*q.X2 + c*q.X3 + e*q.X4
+		ny := a*q.Y1 + b*q.Y2 + c*q.Y3 + e*q.Y4
 
 		dx := nx - x
 		dy := ny - y
@@ -213,13 +210,19 @@ func (q *Cubic) arcLength() float64 {
 }
 
 func (q *Cubic) Rasterize(rc *RasterContext) []Scanline {
+	// the rasterizer cannot stroke cubic segments, so the curve is
+	// stroked as a polyline
+	const n = 16
 	var path raster.Path
-	p1 := fixp(q.X1, q.Y1)
-	p2 := fixp(q.X2, q.Y2)
-	p3 := fixp(q.X3, q.Y3)
-	p4 := fixp(q.X4, q.Y4)
-	path.Start(p1)
-	path.Add3(p2, p3, p4)
+	path.Start(fixp(q.X1, q.Y1))
+	for i := 1; i <= n; i++ {
+		t := float64(i) / n
+		mt := 1 - t
+		a, b, c, d := mt*mt*mt, 3*mt*mt*t, 3*mt*t*t, t*t*t
+		path.Add1(fixp(
+			a*q.X1+b*q.X2+c*q.X3+d*q.X4,
+			a*q.Y1+b*q.Y2+c*q.Y3+d*q.Y4))
+	}
 	width := fix(q.Width)
 	return strokePath(rc, path, width, raster.RoundCapper, raster.RoundJoiner)
 }
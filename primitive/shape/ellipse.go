@@ -101,8 +101,8 @@ func (c *Ellipse) Draw(dc *gg.Context, scale float64) {
 
 func (c *Ellipse) SVG(attrs string) string {
 	return fmt.Sprintf(
-		"<ellipse %s cx=\"%d\" cy=\"%d\" rx=\"%d\" ry=\"%d\" />",
-		attrs, c.X, c.Y, c.Rx, c.Ry)
+		"<ellipse%s cx=\"%d\" cy=\"%d\" rx=\"%d\" ry=\"%d\" />",
+		svgAttrs(attrs), c.X, c.Y, c.Rx, c.Ry)
 }
 
 func (c *Ellipse) Copy() Shape {
@@ -171,30 +171,33 @@ func (c *Ellipse) mutateImpl(plane *Plane, temp float64, actions ActionType) {
 	}
 }
 
+func (c *Ellipse) Valid() bool {
+	return c.Rx > 0 && c.Ry > 0
+}
+
 func (c *Ellipse) Rasterize(rc *RasterContext) []Scanline {
-	w := rc.W
-	h := rc.H
+	minx, miny, maxx, maxy := rc.bounds()
 	lines := rc.Lines[:0]
 	aspect := float64(c.Rx) / float64(c.Ry)
 	for dy := 0; dy < c.Ry; dy++ {
 		y1 := c.Y - dy
 		y2 := c.Y + dy
-		if (y1 < 0 || y1 >= h) && (y2 < 0 || y2 >= h) {
+		if (y1 < miny || y1 > maxy) && (y2 < miny || y2 > maxy) {
 			continue
 		}
 		s := int(math.Sqrt(float64(c.Ry*c.Ry-dy*dy)) * aspect)
 		x1 := c.X - s
 		x2 := c.X + s
-		if x1 < 0 {
-			x1 = 0
+		if x1 < minx {
+			x1 = minx
 		}
-		if x2 >= w {
-			x2 = w - 1
+		if x2 > maxx {
+			x2 = maxx
 		}
-		if y1 >= 0 && y1 < h {
+		if y1 >= miny && y1 <= maxy {
 			lines = append(lines, Scanline{y1, x1, x2, 0xffff})
 		}
-		if y2 >= 0 && y2 < h && dy > 0 {
+		if y2 >= miny && y2 <= maxy && dy > 0 {
 			lines = append(lines, Scanline{y2, x1, x2, 0xffff})
 		}
 	}
@@ -237,8 +240,8 @@ func (c *RotatedEllipse) Draw(dc *gg.Context, scale float64) {
 
 func (c *RotatedEllipse) SVG(attrs string) string {
 	return fmt.Sprintf(
-		"<g transform=\"translate(%f %f) rotate(%f) scale(%f %f)\"><ellipse %s cx=\"0\" cy=\"0\" rx=\"1\" ry=\"1\" /></g>",
-		c.X, c.Y, c.Angle, c.Rx, c.Ry, attrs)
+		"<ellipse%s cx=\"%s\" cy=\"%s\" rx=\"%s\" ry=\"%s\" transform=\"rotate(%s)\" />",
+		svgAttrs(attrs), SVGNum(c.X), SVGNum(c.Y), SVGNum(c.Rx), SVGNum(c.Ry), svgNums(c.Angle, c.X, c.Y))
 }
 
 func (c *RotatedEllipse) Copy() Shape {
@@ -284,6 +287,10 @@ func (c *RotatedEllipse) mutateImpl(plane *Plane, temp float64, actions ActionTy
 	}
 }
 
+func (c *RotatedEllipse) Valid() bool {
+	return c.Rx >= 1 && c.Ry >= 1
+}
+
 func (c *RotatedEllipse) Rasterize(rc *RasterContext) []Scanline {
 	var path raster.Path
 	const n = 16
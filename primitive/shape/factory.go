@@ -5,7 +5,7 @@ type BasicShapes struct {
 	Mask uint32
 }
 
-const biggest = int(ShapeTypePolygon)
+const biggest = int(ShapeTypeStar)
 const allShapes = uint32(1<<uint(biggest)) - 1
 
 // NewBasicShapeFactory returns either the specific shape,
@@ -31,7 +31,7 @@ func (factory *BasicShapes) MakeShape(plane *Plane) Shape {
 
 	t := factory.T
 	for t == ShapeTypeAny {
-		v := plane.Rnd.Intn(biggest - 1)
+		v := plane.Rnd.Intn(biggest)
 		if factory.Mask&(1<<uint32(v)) != 0 {
 			t = ShapeType(v + 1)
 		}
@@ -59,6 +59,12 @@ func (factory *BasicShapes) MakeShape(plane *Plane) Shape {
 		s = NewRotatedEllipse()
 	case ShapeTypePolygon:
 		s = NewPolygon(4, false)
+	case ShapeTypeBrushStroke:
+		s = NewBrushStroke()
+	case ShapeTypeRegularPolygon:
+		s = NewRegularPolygon(6)
+	case ShapeTypeStar:
+		s = NewStar(5)
 	default:
 		panic("Aah!")
 		return nil
@@ -3,7 +3,6 @@ package shape
 import (
 	"fmt"
 	"math"
-	"strings"
 
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/raster"
@@ -41,11 +40,9 @@ func (q *Line) Draw(dc *gg.Context, scale float64) {
 }
 
 func (q *Line) SVG(attrs string) string {
-	// TODO: this is a little silly
-	attrs = strings.Replace(attrs, "fill", "stroke", -1)
 	return fmt.Sprintf(
-		"<path %s fill=\"none\" d=\"M %f %f L %f %f\" stroke-width=\"%f\" />",
-		attrs, q.X1, q.Y1, q.X2, q.Y2, q.Width)
+		"<path%s d=\"M%s L%s\" stroke-width=\"%s\" />",
+		svgAttrs(attrs), svgNums(q.X1, q.Y1), svgNums(q.X2, q.Y2), SVGNum(q.Width))
 }
 
 func (q *Line) Copy() Shape {
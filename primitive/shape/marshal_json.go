@@ -2,6 +2,7 @@ package shape
 
 import (
 	"encoding/json"
+	"errors"
 )
 
 type JsonShape struct {
@@ -15,6 +16,11 @@ type JsonShape struct {
 	Rectangle        *Rectangle        `json:",omitempty"`
 	RotatedRectangle *RotatedRectangle `json:",omitempty"`
 	Triangle         *Triangle         `json:",omitempty"`
+	BrushStroke      *BrushStroke      `json:",omitempty"`
+	RegularPolygon   *RegularPolygon   `json:",omitempty"`
+	Star             *Star             `json:",omitempty"`
+	Sprite           *Sprite           `json:",omitempty"`
+	Constrained      *Constrained      `json:",omitempty"`
 }
 
 func (s JsonShape) toShape() Shape {
@@ -48,6 +54,21 @@ func (s JsonShape) toShape() Shape {
 	if s.Triangle != nil {
 		return s.Triangle
 	}
+	if s.BrushStroke != nil {
+		return s.BrushStroke
+	}
+	if s.RegularPolygon != nil {
+		return s.RegularPolygon
+	}
+	if s.Star != nil {
+		return s.Star
+	}
+	if s.Sprite != nil {
+		return s.Sprite
+	}
+	if s.Constrained != nil {
+		return s.Constrained
+	}
 	return nil
 }
 
@@ -75,6 +96,16 @@ func makeJsonShape(input Shape) JsonShape {
 		s.RotatedRectangle = v
 	case *Triangle:
 		s.Triangle = v
+	case *BrushStroke:
+		s.BrushStroke = v
+	case *RegularPolygon:
+		s.RegularPolygon = v
+	case *Star:
+		s.Star = v
+	case *Sprite:
+		s.Sprite = v
+	case *Constrained:
+		s.Constrained = v
 	default:
 		panic("Unhandled shape")
 	}
@@ -82,16 +113,127 @@ func makeJsonShape(input Shape) JsonShape {
 	return s
 }
 
+// constrainedForJson is a Constrained with its wrapped Shape in a JsonShape.
+type constrainedForJson struct {
+	Shape       JsonShape
+	Region      *RegionConstraint      `json:",omitempty"`
+	Orientation *OrientationConstraint `json:",omitempty"`
+	Size        *SizeConstraint        `json:",omitempty"`
+	Aspect      *AspectConstraint      `json:",omitempty"`
+}
+
+func (c *Constrained) MarshalJSON() ([]byte, error) {
+	return json.Marshal(constrainedForJson{
+		makeJsonShape(c.Shape), c.Region, c.Orientation, c.Size, c.Aspect})
+}
+
+func (c *Constrained) UnmarshalJSON(data []byte) error {
+	x := constrainedForJson{}
+	if err := json.Unmarshal(data, &x); err != nil {
+		return err
+	}
+	c.Shape = x.Shape.toShape()
+	if c.Shape == nil {
+		return errors.New("Constrained requires a Shape")
+	}
+	c.Region = x.Region
+	c.Orientation = x.Orientation
+	c.Size = x.Size
+	c.Aspect = x.Aspect
+	return nil
+}
+
+// spriteForJson has the fields of a Sprite, without its methods.
+type spriteForJson Sprite
+
+// UnmarshalJSON loads the images of the sprite along with its fields, so
+// that a bad Dir in -shapes is an error of the flag, and sprites read from
+// a saved result can be drawn without Init.
+func (s *Sprite) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*spriteForJson)(s)); err != nil {
+		return err
+	}
+	set, err := loadSpriteSet(s.Dir)
+	if err != nil {
+		return err
+	}
+	s.set = set
+	return nil
+}
+
+// starForJson has the fields of a Star, without its methods.
+type starForJson Star
+
+// UnmarshalJSON rejects stars too small to have notches, which no mutation
+// could make valid.
+func (s *Star) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*starForJson)(s)); err != nil {
+		return err
+	}
+	if s.MaxRadius == 1 {
+		return errors.New("Star MaxRadius must be at least 2")
+	}
+	return nil
+}
+
+// brushStrokeForJson has the fields of a BrushStroke, without its methods.
+type brushStrokeForJson BrushStroke
+
+// UnmarshalJSON rejects width limits which no stroke could meet, as a
+// stroke must be at least 0.5 wide somewhere to be valid.
+func (b *BrushStroke) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*brushStrokeForJson)(b)); err != nil {
+		return err
+	}
+	maxWidth := b.MaxWidth
+	if maxWidth <= 0 {
+		maxWidth = 8
+	}
+	if maxWidth < 0.5 {
+		return errors.New("BrushStroke MaxWidth must be at least 0.5")
+	}
+	if b.MinWidth > maxWidth {
+		return errors.New("BrushStroke MinWidth must not be more than MaxWidth")
+	}
+	return nil
+}
+
+// MarshalShape returns the JSON of a single shape, in the same form as the
+// shapes of the -shapes flag, such as {"Triangle":{...}}.
+func MarshalShape(s Shape) ([]byte, error) {
+	return json.Marshal(makeJsonShape(s))
+}
+
+// UnmarshalShape parses the JSON of a single shape.
+func UnmarshalShape(data []byte) (Shape, error) {
+	x := JsonShape{}
+	if err := json.Unmarshal(data, &x); err != nil {
+		return nil, err
+	}
+	s := x.toShape()
+	if s == nil {
+		return nil, errors.New("unrecognized shape: " + string(data))
+	}
+	return s, nil
+}
+
 type SelectedShapesForJson struct {
 	Shapes []JsonShape
 }
 
-func (s SelectedShapesForJson) toSelectedShapes() *SelectedShapes {
+func (s SelectedShapesForJson) toSelectedShapes() (*SelectedShapes, error) {
 	r := &SelectedShapes{}
 	for _, v := range s.Shapes {
-		r.Shapes = append(r.Shapes, v.toShape())
+		shape := v.toShape()
+		if shape == nil {
+			return nil, errors.New("SelectedShapes requires a shape in each entry")
+		}
+		r.Shapes = append(r.Shapes, shape)
 	}
-	return r
+	if len(r.Shapes) == 0 {
+		return nil, errors.New("SelectedShapes requires at least one shape")
+	}
+	return r, nil
 }
 
 func makeSelectedShapesForJson(factory *SelectedShapes) *SelectedShapesForJson {
@@ -129,18 +271,18 @@ func MarshalShapeFactory(input ShapeFactory) string {
 	return string(data)
 }
 
-func UnmarshalShapeFactory(data string) ShapeFactory {
+func UnmarshalShapeFactory(data string) (ShapeFactory, error) {
 	mydata := []byte(data)
 	x := JsonFactory{}
 	if err := json.Unmarshal(mydata, &x); err != nil {
-		panic("Unmarshal failed.")
+		return nil, err
 	}
 
 	if x.BasicShapes != nil {
-		return x.BasicShapes
+		return x.BasicShapes, nil
 	}
 	if x.SelectedShapes != nil {
 		return x.SelectedShapes.toSelectedShapes()
 	}
-	panic("Unmarshal failed.")
+	return nil, errors.New("shapes require BasicShapes or SelectedShapes")
 }
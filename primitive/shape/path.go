@@ -0,0 +1,179 @@
+package shape
+
+import "math"
+
+// PathOp is a command of a Path.
+type PathOp int
+
+const (
+	PathMove  PathOp = iota // start a new subpath at P[0]
+	PathLine                // line to P[0]
+	PathQuad                // quadratic Bézier through control point P[0] to P[1]
+	PathCubic               // cubic Bézier through control points P[0], P[1] to P[2]
+	PathClose               // close the subpath
+)
+
+// PathCommand is a command of a Path with its points.
+type PathCommand struct {
+	Op PathOp
+	P  [3][2]float64
+}
+
+// Path is the outline of a shape in plane units, for vector outputs which
+// cannot use the shape's SVG. Paths with a Width are stroked with round
+// caps and joins; the others are filled with the nonzero rule.
+type Path struct {
+	Commands []PathCommand
+	Width    float64
+}
+
+// Pather is implemented by shapes which can describe their outline as a
+// Path. Sprites cannot, since they are images.
+type Pather interface {
+	Path() Path
+}
+
+// ShapePath returns the outline of the shape, looking through Constrained
+// shapes, or false if the shape has none.
+func ShapePath(s Shape) (Path, bool) {
+	if c, ok := s.(*Constrained); ok {
+		return ShapePath(c.Shape)
+	}
+	if p, ok := s.(Pather); ok {
+		return p.Path(), true
+	}
+	return Path{}, false
+}
+
+func (p *Path) moveTo(x, y float64) {
+	p.Commands = append(p.Commands, PathCommand{Op: PathMove, P: [3][2]float64{{x, y}}})
+}
+
+func (p *Path) lineTo(x, y float64) {
+	p.Commands = append(p.Commands, PathCommand{Op: PathLine, P: [3][2]float64{{x, y}}})
+}
+
+func (p *Path) quadTo(x1, y1, x2, y2 float64) {
+	p.Commands = append(p.Commands, PathCommand{Op: PathQuad, P: [3][2]float64{{x1, y1}, {x2, y2}}})
+}
+
+func (p *Path) cubicTo(x1, y1, x2, y2, x3, y3 float64) {
+	p.Commands = append(p.Commands, PathCommand{Op: PathCubic, P: [3][2]float64{{x1, y1}, {x2, y2}, {x3, y3}}})
+}
+
+func (p *Path) close() {
+	p.Commands = append(p.Commands, PathCommand{Op: PathClose})
+}
+
+// polygonPath returns the closed polygon through the points.
+func polygonPath(xs, ys []float64) Path {
+	var p Path
+	for i := range xs {
+		if i == 0 {
+			p.moveTo(xs[i], ys[i])
+		} else {
+			p.lineTo(xs[i], ys[i])
+		}
+	}
+	p.close()
+	return p
+}
+
+// ellipsePath returns the ellipse with radii rx, ry rotated by angle
+// degrees about its center, as four cubic Béziers.
+func ellipsePath(x, y, rx, ry, angle float64) Path {
+	// the distance of the control points for a quarter circle
+	const k = 0.5522847498307936
+	cos, sin := math.Cos(radians(angle)), math.Sin(radians(angle))
+	pt := func(u, v float64) (float64, float64) {
+		u, v = u*rx, v*ry
+		return x + u*cos - v*sin, y + u*sin + v*cos
+	}
+	var p Path
+	p.moveTo(pt(1, 0))
+	quarters := [4][3][2]float64{
+		{{1, k}, {k, 1}, {0, 1}},
+		{{-k, 1}, {-1, k}, {-1, 0}},
+		{{-1, -k}, {-k, -1}, {0, -1}},
+		{{k, -1}, {1, -k}, {1, 0}},
+	}
+	for _, q := range quarters {
+		x1, y1 := pt(q[0][0], q[0][1])
+		x2, y2 := pt(q[1][0], q[1][1])
+		x3, y3 := pt(q[2][0], q[2][1])
+		p.cubicTo(x1, y1, x2, y2, x3, y3)
+	}
+	p.close()
+	return p
+}
+
+func (t *Triangle) Path() Path {
+	return polygonPath([]float64{t.X1, t.X2, t.X3}, []float64{t.Y1, t.Y2, t.Y3})
+}
+
+func (r *Rectangle) Path() Path {
+	x1, y1, x2, y2 := r.bounds()
+	fx1, fy1 := float64(x1), float64(y1)
+	fx2, fy2 := float64(x2+1), float64(y2+1)
+	return polygonPath([]float64{fx1, fx2, fx2, fx1}, []float64{fy1, fy1, fy2, fy2})
+}
+
+func (r *RotatedRectangle) Path() Path {
+	sx, sy := float64(r.Sx)/2, float64(r.Sy)/2
+	cos, sin := math.Cos(radians(float64(r.Angle))), math.Sin(radians(float64(r.Angle)))
+	var xs, ys []float64
+	for _, c := range [4][2]float64{{-sx, -sy}, {sx, -sy}, {sx, sy}, {-sx, sy}} {
+		xs = append(xs, float64(r.X)+c[0]*cos-c[1]*sin)
+		ys = append(ys, float64(r.Y)+c[0]*sin+c[1]*cos)
+	}
+	return polygonPath(xs, ys)
+}
+
+func (c *Ellipse) Path() Path {
+	return ellipsePath(float64(c.X), float64(c.Y), float64(c.Rx), float64(c.Ry), 0)
+}
+
+func (c *RotatedEllipse) Path() Path {
+	return ellipsePath(c.X, c.Y, c.Rx, c.Ry, c.Angle)
+}
+
+func (p *Polygon) Path() Path {
+	return polygonPath(p.X[:p.Order], p.Y[:p.Order])
+}
+
+func (p *RegularPolygon) Path() Path {
+	return polygonPath(p.points())
+}
+
+func (s *Star) Path() Path {
+	return polygonPath(s.points())
+}
+
+func (b *BrushStroke) Path() Path {
+	return polygonPath(b.outline())
+}
+
+func (q *Line) Path() Path {
+	p := Path{Width: q.Width}
+	p.moveTo(q.X1, q.Y1)
+	p.lineTo(q.X2, q.Y2)
+	return p
+}
+
+func (l *RadialLine) Path() Path {
+	return l.Line.Path()
+}
+
+func (q *Quadratic) Path() Path {
+	p := Path{Width: q.Width}
+	p.moveTo(q.X1, q.Y1)
+	p.quadTo(q.X2, q.Y2, q.X3, q.Y3)
+	return p
+}
+
+func (q *Cubic) Path() Path {
+	p := Path{Width: q.Width}
+	p.moveTo(q.X1, q.Y1)
+	p.cubicTo(q.X2, q.Y2, q.X3, q.Y3, q.X4, q.Y4)
+	return p
+}
@@ -1,9 +1,7 @@
 package shape
 
 import (
-	"fmt"
 	"math"
-	"strings"
 
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/raster"
@@ -46,15 +44,7 @@ func (p *Polygon) Draw(dc *gg.Context, scale float64) {
 }
 
 func (p *Polygon) SVG(attrs string) string {
-	ret := fmt.Sprintf(
-		"<polygon %s points=\"",
-		attrs)
-	points := make([]string, len(p.X))
-	for i := 0; i < len(p.X); i++ {
-		points[i] = fmt.Sprintf("%f,%f", p.X[i], p.Y[i])
-	}
-
-	return ret + strings.Join(points, ",") + "\" />"
+	return svgPolygon(attrs, p.X, p.Y)
 }
 
 func (p *Polygon) Copy() Shape {
@@ -23,11 +23,29 @@ func (p *painter) Paint(spans []raster.Span, done bool) {
 	}
 }
 
+// offsetPath moves the path into the rasterizer of a context in tile mode,
+// whose Dx, Dy move the scanlines back.
+func offsetPath(rc *RasterContext, path raster.Path) raster.Path {
+	if !rc.Tile {
+		return path
+	}
+	dx, dy := fixed.I(rc.W), fixed.I(rc.H)
+	for i := 0; i < len(path); {
+		n := maxInt(int(path[i]), 1)
+		for j := 0; j < n; j++ {
+			path[i+1+2*j] += dx
+			path[i+2+2*j] += dy
+		}
+		i += 2 + 2*n
+	}
+	return path
+}
+
 func fillPath(rc *RasterContext, path raster.Path) []Scanline {
 	r := rc.Rasterizer
 	r.Clear()
 	r.UseNonZeroWinding = true
-	r.AddPath(path)
+	r.AddPath(offsetPath(rc, path))
 	var p painter
 	p.Lines = rc.Lines[:0]
 	r.Rasterize(&p)
@@ -38,7 +56,7 @@ func strokePath(rc *RasterContext, path raster.Path, width fixed.Int26_6, cr ras
 	r := rc.Rasterizer
 	r.Clear()
 	r.UseNonZeroWinding = true
-	r.AddStroke(path, width, cr, jr)
+	r.AddStroke(offsetPath(rc, path), width, cr, jr)
 	var p painter
 	p.Lines = rc.Lines[:0]
 	r.Rasterize(&p)
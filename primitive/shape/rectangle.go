@@ -49,8 +49,8 @@ func (r *Rectangle) SVG(attrs string) string {
 	w := x2 - x1 + 1
 	h := y2 - y1 + 1
 	return fmt.Sprintf(
-		"<rect %s x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" />",
-		attrs, x1, y1, w, h)
+		"<rect%s x=\"%d\" y=\"%d\" width=\"%d\" height=\"%d\" />",
+		svgAttrs(attrs), x1, y1, w, h)
 }
 
 func (r *Rectangle) Copy() Shape {
@@ -175,8 +175,9 @@ func (r *RotatedRectangle) Draw(dc *gg.Context, scale float64) {
 
 func (r *RotatedRectangle) SVG(attrs string) string {
 	return fmt.Sprintf(
-		"<g transform=\"translate(%d %d) rotate(%d) scale(%d %d)\"><rect %s x=\"-0.5\" y=\"-0.5\" width=\"1\" height=\"1\" /></g>",
-		r.X, r.Y, r.Angle, r.Sx, r.Sy, attrs)
+		"<rect%s x=\"%s\" y=\"%s\" width=\"%d\" height=\"%d\" transform=\"rotate(%d %d %d)\" />",
+		svgAttrs(attrs), SVGNum(float64(r.X)-float64(r.Sx)/2), SVGNum(float64(r.Y)-float64(r.Sy)/2),
+		r.Sx, r.Sy, r.Angle, r.X, r.Y)
 }
 
 func (r *RotatedRectangle) Copy() Shape {
@@ -240,8 +241,7 @@ func (r *RotatedRectangle) Valid() bool {
 }
 
 func (r *RotatedRectangle) Rasterize(rc *RasterContext) []Scanline {
-	w := rc.W
-	h := rc.H
+	left, top, right, bottom := rc.bounds()
 	sx, sy := float64(r.Sx), float64(r.Sy)
 	angle := radians(float64(r.Angle))
 	rx1, ry1 := rotate(-sx/2, -sy/2, angle)
@@ -258,7 +258,7 @@ func (r *RotatedRectangle) Rasterize(rc *RasterContext) []Scanline {
 	min := make([]int, n)
 	max := make([]int, n)
 	for i := range min {
-		min[i] = w
+		min[i] = right + 1
 	}
 	xs := []int{x1, x2, x3, x4, x1}
 	ys := []int{y1, y2, y3, y4, y1}
@@ -278,11 +278,11 @@ func (r *RotatedRectangle) Rasterize(rc *RasterContext) []Scanline {
 	lines := rc.Lines[:0]
 	for i := 0; i < n; i++ {
 		y := miny + i
-		if y < 0 || y >= h {
+		if y < top || y > bottom {
 			continue
 		}
-		a := maxInt(min[i], 0)
-		b := minInt(max[i], w-1)
+		a := maxInt(min[i], left)
+		b := minInt(max[i], right)
 		if b >= a {
 			lines = append(lines, Scanline{y, a, b, 0xffff})
 		}
@@ -0,0 +1,285 @@
+package shape
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/fogleman/gg"
+	"github.com/golang/freetype/raster"
+)
+
+// RegularPolygon represents a regular polygon with Sides sides, centered
+// at X, Y and rotated by Angle degrees.
+type RegularPolygon struct {
+	X, Y      float64
+	Radius    float64
+	Angle     float64
+	Sides     int
+	MaxRadius int
+}
+
+func NewRegularPolygon(sides int) *RegularPolygon {
+	p := &RegularPolygon{}
+	p.Sides = sides
+	return p
+}
+
+func (p *RegularPolygon) Init(plane *Plane) {
+	rnd := plane.Rnd
+	if p.Sides < 3 {
+		p.Sides = 6
+	}
+	p.X = randomW(plane)
+	p.Y = randomH(plane)
+	p.Radius = rnd.Float64()*float64(maxRadius(p.MaxRadius)) + 1
+	p.Angle = rnd.Float64() * 360 / float64(p.Sides)
+	p.mutateImpl(plane, 1.0, 2, ActionAny)
+}
+
+func (p *RegularPolygon) points() (xs, ys []float64) {
+	return starPoints(p.X, p.Y, p.Radius, p.Radius, p.Angle, p.Sides)
+}
+
+func (p *RegularPolygon) Draw(dc *gg.Context, scale float64) {
+	xs, ys := p.points()
+	drawPoints(dc, xs, ys)
+}
+
+func (p *RegularPolygon) SVG(attrs string) string {
+	xs, ys := p.points()
+	return svgPolygon(attrs, xs, ys)
+}
+
+func (p *RegularPolygon) Copy() Shape {
+	a := *p
+	return &a
+}
+
+func (p *RegularPolygon) Mutate(plane *Plane, temp float64) {
+	p.mutateImpl(plane, temp, 10, ActionAny)
+}
+
+func (p *RegularPolygon) mutateImpl(plane *Plane, temp float64, rollback int, actions ActionType) {
+	if actions == ActionNone {
+		return
+	}
+	w := float64(plane.W - 1)
+	h := float64(plane.H - 1)
+	rnd := plane.Rnd
+	maxr := float64(maxRadius(p.MaxRadius))
+	scale := 16 * temp
+	save := *p
+	for {
+		switch rnd.Intn(3) {
+		case 0: // Translate
+			if (actions & ActionTranslate) == 0 {
+				continue
+			}
+			p.X = clamp(p.X+rnd.NormFloat64()*scale, 0, w)
+			p.Y = clamp(p.Y+rnd.NormFloat64()*scale, 0, h)
+		case 1: // Scale
+			if (actions & ActionScale) == 0 {
+				continue
+			}
+			p.Radius = clamp(p.Radius+rnd.NormFloat64()*scale, 1, maxr)
+		case 2: // Rotate
+			if (actions & ActionRotate) == 0 {
+				continue
+			}
+			p.Angle = p.Angle + rnd.NormFloat64()*32*temp
+		}
+		if p.Valid() {
+			break
+		}
+		if rollback > 0 {
+			*p = save
+			rollback -= 1
+		}
+	}
+}
+
+func (p *RegularPolygon) Valid() bool {
+	return p.Radius >= 1
+}
+
+func (p *RegularPolygon) Rasterize(rc *RasterContext) []Scanline {
+	xs, ys := p.points()
+	return fillPoints(rc, xs, ys)
+}
+
+// starTries is the number of mutations tried, after the rollbacks, before
+// a Star gives up and keeps its previous state.
+const starTries = 1000
+
+// Star represents a star with Points points, centered at X, Y and rotated
+// by Angle degrees. The tips lie on the Outer radius and the notches
+// between them on the Inner radius. MaxRadius limits the outer radius and
+// is at least 2, as the notches need room inside it.
+type Star struct {
+	X, Y      float64
+	Outer     float64
+	Inner     float64
+	Angle     float64
+	Points    int
+	MaxRadius int
+}
+
+func NewStar(points int) *Star {
+	s := &Star{}
+	s.Points = points
+	return s
+}
+
+func (s *Star) Init(plane *Plane) {
+	rnd := plane.Rnd
+	if s.Points < 3 {
+		s.Points = 5
+	}
+	s.X = randomW(plane)
+	s.Y = randomH(plane)
+	s.Outer = rnd.Float64()*float64(maxRadius(s.MaxRadius)) + 2
+	s.Inner = s.Outer * (0.3 + rnd.Float64()*0.4)
+	s.Angle = rnd.Float64() * 360 / float64(s.Points)
+	s.mutateImpl(plane, 1.0, 2, ActionAny)
+}
+
+func (s *Star) points() (xs, ys []float64) {
+	return starPoints(s.X, s.Y, s.Outer, s.Inner, s.Angle, s.Points)
+}
+
+func (s *Star) Draw(dc *gg.Context, scale float64) {
+	xs, ys := s.points()
+	drawPoints(dc, xs, ys)
+}
+
+func (s *Star) SVG(attrs string) string {
+	xs, ys := s.points()
+	return svgPolygon(attrs, xs, ys)
+}
+
+func (s *Star) Copy() Shape {
+	a := *s
+	return &a
+}
+
+func (s *Star) Mutate(plane *Plane, temp float64) {
+	s.mutateImpl(plane, temp, 10, ActionAny)
+}
+
+func (s *Star) mutateImpl(plane *Plane, temp float64, rollback int, actions ActionType) {
+	if actions == ActionNone {
+		return
+	}
+	w := float64(plane.W - 1)
+	h := float64(plane.H - 1)
+	rnd := plane.Rnd
+	maxr := float64(maxRadius(s.MaxRadius))
+	scale := 16 * temp
+	save := *s
+	for i := 0; ; i++ {
+		switch rnd.Intn(4) {
+		case 0: // Translate
+			if (actions & ActionTranslate) == 0 {
+				continue
+			}
+			s.X = clamp(s.X+rnd.NormFloat64()*scale, 0, w)
+			s.Y = clamp(s.Y+rnd.NormFloat64()*scale, 0, h)
+		case 1: // Scale, keeping the ratio of the radii
+			if (actions & ActionScale) == 0 {
+				continue
+			}
+			ratio := s.Inner / s.Outer
+			s.Outer = clamp(s.Outer+rnd.NormFloat64()*scale, 2, maxr)
+			s.Inner = s.Outer * ratio
+		case 2: // Change the depth of the notches
+			if (actions & ActionMutate) == 0 {
+				continue
+			}
+			s.Inner = clamp(s.Inner+rnd.NormFloat64()*scale/2, 1, s.Outer)
+		case 3: // Rotate
+			if (actions & ActionRotate) == 0 {
+				continue
+			}
+			s.Angle = s.Angle + rnd.NormFloat64()*32*temp
+		}
+		if s.Valid() {
+			break
+		}
+		if rollback > 0 {
+			*s = save
+			rollback -= 1
+		} else if i >= starTries {
+			// no valid star is in reach, so keep the last one
+			*s = save
+			break
+		}
+	}
+}
+
+func (s *Star) Valid() bool {
+	return s.Inner >= 1 && s.Inner < s.Outer*0.9
+}
+
+func (s *Star) Rasterize(rc *RasterContext) []Scanline {
+	xs, ys := s.points()
+	return fillPoints(rc, xs, ys)
+}
+
+// maxRadius returns the largest radius allowed for a shape whose
+// MaxRadius field is r.
+func maxRadius(r int) int {
+	if r > 0 {
+		return r
+	}
+	return 32
+}
+
+// starPoints returns the vertices of an n-pointed star. Every other vertex
+// lies on the inner radius; when inner == outer this is a regular n-gon.
+func starPoints(x, y, outer, inner, angle float64, n int) (xs, ys []float64) {
+	if inner == outer {
+		xs = make([]float64, n)
+		ys = make([]float64, n)
+		for i := 0; i < n; i++ {
+			a := radians(angle) + 2*math.Pi*float64(i)/float64(n)
+			xs[i] = x + outer*math.Cos(a)
+			ys[i] = y + outer*math.Sin(a)
+		}
+		return
+	}
+	xs = make([]float64, 2*n)
+	ys = make([]float64, 2*n)
+	for i := 0; i < 2*n; i++ {
+		r := outer
+		if i%2 == 1 {
+			r = inner
+		}
+		a := radians(angle) + math.Pi*float64(i)/float64(n)
+		xs[i] = x + r*math.Cos(a)
+		ys[i] = y + r*math.Sin(a)
+	}
+	return
+}
+
+func drawPoints(dc *gg.Context, xs, ys []float64) {
+	dc.NewSubPath()
+	for i := range xs {
+		dc.LineTo(xs[i], ys[i])
+	}
+	dc.ClosePath()
+	dc.Fill()
+}
+
+func svgPolygon(attrs string, xs, ys []float64) string {
+	return fmt.Sprintf("<polygon%s points=\"%s\" />", svgAttrs(attrs), svgPoints(xs, ys))
+}
+
+func fillPoints(rc *RasterContext, xs, ys []float64) []Scanline {
+	var path raster.Path
+	path.Start(fixp(xs[0], ys[0]))
+	for i := 1; i < len(xs); i++ {
+		path.Add1(fixp(xs[i], ys[i]))
+	}
+	path.Add1(fixp(xs[0], ys[0]))
+	return fillPath(rc, path)
+}
@@ -10,12 +10,50 @@ import (
 type Plane struct {
 	W, H int
 	Rnd  *rand.Rand
+	rc   *RasterContext // scratch space for shapes which rasterize to mutate
+}
+
+// rasterContext returns a RasterContext for the plane which shapes may use
+// while mutating, such as to check a Constrained shape.
+func (p *Plane) rasterContext() *RasterContext {
+	if p.rc == nil {
+		p.rc = &RasterContext{
+			W:          p.W,
+			H:          p.H,
+			Lines:      make([]Scanline, 0, 4096),
+			Rasterizer: raster.NewRasterizer(p.W, p.H),
+		}
+	}
+	return p.rc
 }
 
 type RasterContext struct {
 	W, H       int
 	Lines      []Scanline
 	Rasterizer *raster.Rasterizer
+	Tile       bool // shapes may cover the tiles around the plane
+}
+
+// SetTile switches the context in or out of tile mode. In tile mode shapes
+// are rasterized over the plane and the eight tiles around it, so the
+// scanlines may lie outside of the plane and must be wrapped back onto it.
+func (rc *RasterContext) SetTile(tile bool) {
+	rc.Tile = tile
+	if tile {
+		rc.Rasterizer = raster.NewRasterizer(3*rc.W, 3*rc.H)
+		rc.Rasterizer.Dx = -rc.W
+		rc.Rasterizer.Dy = -rc.H
+	} else {
+		rc.Rasterizer = raster.NewRasterizer(rc.W, rc.H)
+	}
+}
+
+// bounds returns the first and last pixels which a shape may cover.
+func (rc *RasterContext) bounds() (x1, y1, x2, y2 int) {
+	if rc.Tile {
+		return -rc.W, -rc.H, 2*rc.W - 1, 2*rc.H - 1
+	}
+	return 0, 0, rc.W - 1, rc.H - 1
 }
 
 // TODO: Shape should have an area method.
@@ -25,10 +63,18 @@ type Shape interface {
 	Rasterize(*RasterContext) []Scanline
 	Copy() Shape
 	Mutate(*Plane, float64)
+	Valid() bool
 	Draw(dc *gg.Context, scale float64)
 	SVG(attrs string) string
 }
 
+// SVGDefiner is implemented by shapes whose SVG refers to shared
+// definitions, such as the image masks used by Sprite. Each distinct
+// definition is written once in the <defs> of the document.
+type SVGDefiner interface {
+	SVGDefs() []string
+}
+
 type ShapeFactory interface {
 	MakeShape(*Plane) Shape
 }
@@ -47,6 +93,9 @@ const (
 	ShapeTypeLine
 	ShapeTypeRotatedEllipse
 	ShapeTypePolygon // 10
+	ShapeTypeBrushStroke
+	ShapeTypeRegularPolygon
+	ShapeTypeStar
 )
 
 type ActionType int
@@ -0,0 +1,308 @@
+package shape
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"hash/fnv"
+	"image"
+	"image/draw"
+	"image/png"
+	"io/ioutil"
+	"math"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+
+	"github.com/fogleman/gg"
+)
+
+// spriteImage is a single alpha mask loaded from a PNG file.
+type spriteImage struct {
+	Alpha *image.Alpha
+	Data  []byte // the original PNG, embedded in SVG output
+}
+
+// spriteSet is the list of sprites loaded from a directory.
+type spriteSet struct {
+	ID      string
+	Sprites []spriteImage
+}
+
+var (
+	spriteMu   sync.Mutex
+	spriteSets = map[string]*spriteSet{}
+)
+
+// loadSpriteSet reads every PNG in dir, keeping only the alpha channel.
+// Sets are cached, so every shape using the same directory shares them.
+func loadSpriteSet(dir string) (*spriteSet, error) {
+	spriteMu.Lock()
+	defer spriteMu.Unlock()
+	if set, ok := spriteSets[dir]; ok {
+		return set, nil
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.[pP][nN][gG]"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(paths)
+	h := fnv.New32a()
+	h.Write([]byte(dir))
+	set := &spriteSet{ID: fmt.Sprintf("sprite%08x", h.Sum32())}
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		im, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", path, err)
+		}
+		b := im.Bounds()
+		alpha := image.NewAlpha(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(alpha, alpha.Rect, im, b.Min, draw.Src)
+		set.Sprites = append(set.Sprites, spriteImage{alpha, data})
+		vv("Sprite: %s %dx%d\n", path, b.Dx(), b.Dy())
+	}
+	if len(set.Sprites) == 0 {
+		return nil, fmt.Errorf("no PNG sprites found in %s", dir)
+	}
+	spriteSets[dir] = set
+	return set, nil
+}
+
+// Sprite stamps one of the alpha-masked PNG images found in Dir. The
+// sprite's alpha channel is used as the coverage of the shape, and the
+// color picker chooses its tint. Size is the length of the longer side
+// of the sprite and Angle its rotation in degrees.
+type Sprite struct {
+	Dir     string
+	Index   int
+	X, Y    float64
+	Size    float64
+	Angle   float64
+	MinSize float64
+	MaxSize float64
+	set     *spriteSet
+}
+
+// NewSprite returns a sprite of the PNG images in dir, which are loaded
+// here so that a bad directory is reported before any shape is made.
+func NewSprite(dir string) (*Sprite, error) {
+	set, err := loadSpriteSet(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := &Sprite{}
+	s.Dir = dir
+	s.MinSize = 4
+	s.MaxSize = 64
+	s.set = set
+	return s, nil
+}
+
+func (s *Sprite) Init(plane *Plane) {
+	if s.set == nil {
+		panic("Sprite has no images; use NewSprite or -shapes")
+	}
+	if s.MaxSize <= 0 {
+		s.MaxSize = 64
+	}
+	s.MinSize = clamp(s.MinSize, 1, s.MaxSize)
+	rnd := plane.Rnd
+	s.Index = rnd.Intn(len(s.set.Sprites))
+	s.X = randomW(plane)
+	s.Y = randomH(plane)
+	s.Size = s.MinSize + rnd.Float64()*(s.MaxSize-s.MinSize)
+	s.Angle = rnd.Float64() * 360
+}
+
+func (s *Sprite) sprite() *image.Alpha {
+	return s.set.Sprites[s.Index].Alpha
+}
+
+// transform returns the scale from sprite pixels to plane units along
+// with the cosine and sine of the rotation.
+func (s *Sprite) transform() (k, cos, sin float64) {
+	size := s.sprite().Rect.Size()
+	k = s.Size / float64(maxInt(size.X, size.Y))
+	a := radians(s.Angle)
+	return k, math.Cos(a), math.Sin(a)
+}
+
+// corners returns the four corners of the sprite in plane coordinates.
+func (s *Sprite) corners() (xs, ys [4]float64) {
+	size := s.sprite().Rect.Size()
+	k, cos, sin := s.transform()
+	hw := float64(size.X) * k / 2
+	hh := float64(size.Y) * k / 2
+	for i, p := range [4][2]float64{{-hw, -hh}, {hw, -hh}, {hw, hh}, {-hw, hh}} {
+		xs[i] = s.X + p[0]*cos - p[1]*sin
+		ys[i] = s.Y + p[0]*sin + p[1]*cos
+	}
+	return
+}
+
+// alphaAt returns the bilinearly-interpolated alpha, in [0, 0xffff], at the
+// point x, y in plane coordinates.
+func (s *Sprite) alphaAt(x, y, k, cos, sin float64) uint32 {
+	im := s.sprite()
+	size := im.Rect.Size()
+	dx, dy := x-s.X, y-s.Y
+	u := (dx*cos+dy*sin)/k + float64(size.X)/2 - 0.5
+	v := (-dx*sin+dy*cos)/k + float64(size.Y)/2 - 0.5
+	x0, y0 := int(math.Floor(u)), int(math.Floor(v))
+	fx, fy := u-float64(x0), v-float64(y0)
+	at := func(x, y int) float64 {
+		if x < 0 || y < 0 || x >= size.X || y >= size.Y {
+			return 0
+		}
+		return float64(im.Pix[y*im.Stride+x])
+	}
+	a := at(x0, y0)*(1-fx)*(1-fy) + at(x0+1, y0)*fx*(1-fy) +
+		at(x0, y0+1)*(1-fx)*fy + at(x0+1, y0+1)*fx*fy
+	return uint32(a+0.5) * 0x101
+}
+
+func (s *Sprite) Draw(dc *gg.Context, scale float64) {
+	// Render the transformed sprite into a mask covering the whole context,
+	// and fill the sprite's bounds through it with the current color.
+	xs, ys := s.corners()
+	ox, oy := dc.TransformPoint(0, 0)
+	ax, ay := dc.TransformPoint(1, 0)
+	bx, by := dc.TransformPoint(0, 1)
+	ax, ay, bx, by = ax-ox, ay-oy, bx-ox, by-oy
+	det := ax*by - ay*bx
+	mask := image.NewAlpha(image.Rect(0, 0, dc.Width(), dc.Height()))
+	x0, y0 := math.Inf(1), math.Inf(1)
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+	for i := range xs {
+		x, y := dc.TransformPoint(xs[i], ys[i])
+		x0, y0 = math.Min(x0, x), math.Min(y0, y)
+		x1, y1 = math.Max(x1, x), math.Max(y1, y)
+	}
+	k, cos, sin := s.transform()
+	for py := maxInt(int(y0), 0); py <= minInt(int(y1), dc.Height()-1); py++ {
+		for px := maxInt(int(x0), 0); px <= minInt(int(x1), dc.Width()-1); px++ {
+			// invert the context transform for the pixel center
+			dx, dy := float64(px)+0.5-ox, float64(py)+0.5-oy
+			x := (dx*by - dy*bx) / det
+			y := (dy*ax - dx*ay) / det
+			mask.Pix[py*mask.Stride+px] = uint8(s.alphaAt(x, y, k, cos, sin) >> 8)
+		}
+	}
+	dc.SetMask(mask)
+	drawPoints(dc, xs[:], ys[:])
+	dc.ResetClip()
+}
+
+func (s *Sprite) SVG(attrs string) string {
+	size := s.sprite().Rect.Size()
+	k, _, _ := s.transform()
+	return fmt.Sprintf(
+		"<g transform=\"translate(%s) rotate(%s) scale(%s)\"><rect%s x=\"%s\" y=\"%s\" width=\"%d\" height=\"%d\" mask=\"url(#%s)\" /></g>",
+		svgNums(s.X, s.Y), SVGNum(s.Angle), strconv.FormatFloat(k, 'g', -1, 64), svgAttrs(attrs),
+		SVGNum(-float64(size.X)/2), SVGNum(-float64(size.Y)/2), size.X, size.Y, s.maskID())
+}
+
+func (s *Sprite) maskID() string {
+	return fmt.Sprintf("%s-%d", s.set.ID, s.Index)
+}
+
+// SVGDefs returns the alpha mask referenced by the SVG of this sprite.
+func (s *Sprite) SVGDefs() []string {
+	size := s.sprite().Rect.Size()
+	data := base64.StdEncoding.EncodeToString(s.set.Sprites[s.Index].Data)
+	return []string{fmt.Sprintf(
+		"<mask id=\"%s\" mask-type=\"alpha\" style=\"mask-type:alpha\"><image x=\"%s\" y=\"%s\" width=\"%d\" height=\"%d\" href=\"data:image/png;base64,%s\" /></mask>",
+		s.maskID(), SVGNum(-float64(size.X)/2), SVGNum(-float64(size.Y)/2), size.X, size.Y, data)}
+}
+
+func (s *Sprite) Copy() Shape {
+	a := *s
+	return &a
+}
+
+func (s *Sprite) Mutate(plane *Plane, temp float64) {
+	s.mutateImpl(plane, temp, 10, ActionAny)
+}
+
+func (s *Sprite) mutateImpl(plane *Plane, temp float64, rollback int, actions ActionType) {
+	if actions == ActionNone {
+		return
+	}
+	w := float64(plane.W - 1)
+	h := float64(plane.H - 1)
+	rnd := plane.Rnd
+	scale := 16 * temp
+	save := *s
+	for {
+		switch rnd.Intn(4) {
+		case 0: // Translate
+			if (actions & ActionTranslate) == 0 {
+				continue
+			}
+			s.X = clamp(s.X+rnd.NormFloat64()*scale, 0, w)
+			s.Y = clamp(s.Y+rnd.NormFloat64()*scale, 0, h)
+		case 1: // Scale
+			if (actions & ActionScale) == 0 {
+				continue
+			}
+			s.Size = clamp(s.Size+rnd.NormFloat64()*scale, s.MinSize, s.MaxSize)
+		case 2: // Rotate
+			if (actions & ActionRotate) == 0 {
+				continue
+			}
+			s.Angle = s.Angle + rnd.NormFloat64()*32*temp
+		case 3: // Pick another sprite
+			if (actions&ActionMutate) == 0 || len(s.set.Sprites) < 2 {
+				continue
+			}
+			s.Index = rnd.Intn(len(s.set.Sprites))
+		}
+		if s.Valid() {
+			break
+		}
+		if rollback > 0 {
+			*s = save
+			rollback -= 1
+		}
+	}
+}
+
+func (s *Sprite) Valid() bool {
+	return s.Size >= 1
+}
+
+func (s *Sprite) Rasterize(rc *RasterContext) []Scanline {
+	xs, ys := s.corners()
+	x0, y0 := math.Inf(1), math.Inf(1)
+	x1, y1 := math.Inf(-1), math.Inf(-1)
+	for i := range xs {
+		x0, y0 = math.Min(x0, xs[i]), math.Min(y0, ys[i])
+		x1, y1 = math.Max(x1, xs[i]), math.Max(y1, ys[i])
+	}
+	k, cos, sin := s.transform()
+	minx, miny, maxx, maxy := rc.bounds()
+	x2, y2 := minInt(int(math.Floor(x1)), maxx), minInt(int(math.Floor(y1)), maxy)
+	lines := rc.Lines[:0]
+	for y := maxInt(int(math.Floor(y0)), miny); y <= y2; y++ {
+		start, alpha := -1, uint32(0)
+		for x := maxInt(int(math.Floor(x0)), minx); x <= x2+1; x++ {
+			var a uint32
+			if x <= x2 {
+				a = s.alphaAt(float64(x)+0.5, float64(y)+0.5, k, cos, sin)
+			}
+			if start >= 0 && a != alpha {
+				lines = append(lines, Scanline{y, start, x - 1, alpha})
+				start = -1
+			}
+			if start < 0 && a != 0 {
+				start, alpha = x, a
+			}
+		}
+	}
+	return lines
+}
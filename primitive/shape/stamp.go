@@ -1,9 +1,7 @@
 package shape
 
 import (
-	"fmt"
 	"math"
-	"strings"
 
 	"github.com/fogleman/gg"
 	"github.com/golang/freetype/raster"
@@ -35,15 +33,12 @@ func (s *Stamp) Draw(dc *gg.Context, scale float64) {
 }
 
 func (s *Stamp) SVG(attrs string) string {
-	ret := fmt.Sprintf(
-		"<polygon %s points=\"",
-		attrs)
-	points := make([]string, len(s.X))
-	for i := 0; i < len(s.X); i++ {
-		points[i] = fmt.Sprintf("%f,%f", s.X1+s.X[i], s.Y1+s.Y[i])
+	xs := make([]float64, len(s.X))
+	ys := make([]float64, len(s.Y))
+	for i := range s.X {
+		xs[i], ys[i] = s.X1+s.X[i], s.Y1+s.Y[i]
 	}
-
-	return ret + strings.Join(points, ",") + "\" />"
+	return svgPolygon(attrs, xs, ys)
 }
 
 func (s *Stamp) Copy() Shape {
@@ -79,6 +74,10 @@ func (s *Stamp) mutateImpl(plane *Plane, temp float64, rollback int, actions Act
 	s.Y1 = clamp(s.Y1+b, -m, h)
 }
 
+func (s *Stamp) Valid() bool {
+	return len(s.X) > 0 && len(s.X) == len(s.Y)
+}
+
 func (s *Stamp) Rasterize(rc *RasterContext) []Scanline {
 	var path raster.Path
 	path.Start(fixp(s.X1+s.X[0], s.Y1+s.Y[0]))
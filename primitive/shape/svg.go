@@ -0,0 +1,55 @@
+package shape
+
+import (
+	"strconv"
+	"strings"
+)
+
+// SVGPrecision is the number of decimals of the coordinates written in SVG
+// output. It is set once, before any SVG is written.
+var SVGPrecision = 6
+
+// SVGNum formats a number for SVG output to SVGPrecision decimals,
+// without trailing zeros or a leading zero.
+func SVGNum(x float64) string {
+	s := strconv.FormatFloat(x, 'f', SVGPrecision, 64)
+	if strings.Contains(s, ".") {
+		s = strings.TrimRight(strings.TrimRight(s, "0"), ".")
+	}
+	switch {
+	case s == "-0":
+		return "0"
+	case strings.HasPrefix(s, "0."):
+		return s[1:]
+	case strings.HasPrefix(s, "-0."):
+		return "-" + s[2:]
+	}
+	return s
+}
+
+// svgNums formats the numbers separated by spaces.
+func svgNums(xs ...float64) string {
+	s := make([]string, len(xs))
+	for i, x := range xs {
+		s[i] = SVGNum(x)
+	}
+	return strings.Join(s, " ")
+}
+
+// svgPoints formats the points as x,y pairs separated by spaces.
+func svgPoints(xs, ys []float64) string {
+	points := make([]string, len(xs))
+	for i := range xs {
+		points[i] = SVGNum(xs[i]) + "," + SVGNum(ys[i])
+	}
+	return strings.Join(points, " ")
+}
+
+// svgAttrs returns the attributes with the space which separates them from
+// the element name, or nothing if there are none.
+func svgAttrs(attrs string) string {
+	if attrs == "" {
+		return ""
+	}
+	return " " + attrs
+}
@@ -1,7 +1,6 @@
 package shape
 
 import (
-	"fmt"
 	"math"
 
 	"github.com/fogleman/gg"
@@ -46,9 +45,7 @@ func (t *Triangle) Draw(dc *gg.Context, scale float64) {
 }
 
 func (t *Triangle) SVG(attrs string) string {
-	return fmt.Sprintf(
-		"<polygon %s points=\"%f,%f %f,%f %f,%f\" />",
-		attrs, t.X1, t.Y1, t.X2, t.Y2, t.X3, t.Y3)
+	return svgPolygon(attrs, []float64{t.X1, t.X2, t.X3}, []float64{t.Y1, t.Y2, t.Y3})
 }
 
 func (t *Triangle) Copy() Shape {
@@ -14,7 +14,9 @@ func NewState(worker *Worker, shape shape.Shape, alpha int) *State {
 	mutateAlpha := false
 	if alpha == 0 {
 		alpha = 128
-		mutateAlpha = true
+		// there is no need to search for alpha if the picker solves it
+		solver, ok := worker.ColorPicker.(AlphaSolver)
+		mutateAlpha = !ok || !solver.SolvesAlpha()
 	}
 	alpha = clampInt(alpha, 1, 255)
 	return &State{worker, shape, alpha, mutateAlpha, -1}
@@ -0,0 +1,404 @@
+package primitive
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"unicode"
+
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+// svgNode is an element of an SVG document, with its attributes and the
+// elements inside it.
+type svgNode struct {
+	XMLName  xml.Name
+	Attrs    []xml.Attr `xml:",any,attr"`
+	Children []svgNode  `xml:",any"`
+}
+
+// attr returns the value of the attribute, or "" if it is not set.
+func (n *svgNode) attr(name string) string {
+	for _, a := range n.Attrs {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+	return ""
+}
+
+// svgPaint is the style a shape inherits from the groups around it.
+type svgPaint struct {
+	Fill, FillOpacity     string
+	Stroke, StrokeOpacity string
+	Blend                 string
+}
+
+// with returns the paint overridden by the attributes of the element.
+func (p svgPaint) with(n *svgNode) svgPaint {
+	for _, v := range []struct {
+		name  string
+		value *string
+	}{
+		{"fill", &p.Fill},
+		{"fill-opacity", &p.FillOpacity},
+		{"stroke", &p.Stroke},
+		{"stroke-opacity", &p.StrokeOpacity},
+	} {
+		if a := n.attr(v.name); a != "" {
+			*v.value = a
+		}
+	}
+	for _, decl := range strings.Split(n.attr("style"), ";") {
+		kv := strings.SplitN(decl, ":", 2)
+		if len(kv) == 2 && strings.TrimSpace(kv[0]) == "mix-blend-mode" {
+			p.Blend = strings.TrimSpace(kv[1])
+		}
+	}
+	return p
+}
+
+// color returns the color of the paint and its opacity, which is the
+// stroke of stroked shapes and the fill of the others.
+func (p svgPaint) color(stroked bool) (Color, error) {
+	c, opacity := p.Fill, p.FillOpacity
+	if stroked {
+		c, opacity = p.Stroke, p.StrokeOpacity
+	}
+	if !strings.HasPrefix(c, "#") {
+		return Color{}, fmt.Errorf("unsupported paint: %q", c)
+	}
+	color := MakeHexColor(c)
+	if opacity != "" {
+		a, err := strconv.ParseFloat(opacity, 64)
+		if err != nil {
+			return Color{}, err
+		}
+		color.A = clampInt(int(math.Round(a*255)), 0, 255)
+	}
+	return color, nil
+}
+
+// blend returns the blend mode of the paint, from its mix-blend-mode.
+func (p svgPaint) blend() (BlendMode, error) {
+	if p.Blend == BlendAdd.css() {
+		return BlendAdd, nil
+	}
+	return ParseBlendMode(p.Blend)
+}
+
+// svgNumbers parses the numbers of an attribute, which are separated by
+// spaces or commas, after the letters of path commands are dropped. The
+// letters are returned in order.
+func svgNumbers(s string) ([]float64, string, error) {
+	var letters []rune
+	fields := strings.FieldsFunc(s, func(r rune) bool {
+		if unicode.IsLetter(r) {
+			letters = append(letters, r)
+			return true
+		}
+		return r == ' ' || r == ',' || r == '(' || r == ')'
+	})
+	xs := make([]float64, len(fields))
+	for i, f := range fields {
+		x, err := strconv.ParseFloat(f, 64)
+		if err != nil {
+			return nil, "", err
+		}
+		xs[i] = x
+	}
+	return xs, string(letters), nil
+}
+
+// svgAttrNumbers parses the numbers of the named attributes, in order.
+func svgAttrNumbers(n *svgNode, names ...string) ([]float64, error) {
+	xs := make([]float64, len(names))
+	for i, name := range names {
+		x, err := strconv.ParseFloat(n.attr(name), 64)
+		if err != nil {
+			return nil, fmt.Errorf("<%s> %s: %v", n.XMLName.Local, name, err)
+		}
+		xs[i] = x
+	}
+	return xs, nil
+}
+
+// svgShape returns the shape drawn by the element, as written by the SVG
+// method of the shapes, and whether it is stroked. Polygons of three
+// points are triangles and all others are polygons, so regular polygons,
+// stars, brush strokes and stamps come back as the polygons they draw.
+func svgShape(n *svgNode) (shape.Shape, bool, error) {
+	rotate, rotation, err := svgNumbers(n.attr("transform"))
+	if err != nil {
+		return nil, false, err
+	}
+	if rotation != "" && rotation != "rotate" {
+		return nil, false, fmt.Errorf("<%s> has an unsupported transform", n.XMLName.Local)
+	}
+	switch n.XMLName.Local {
+	case "polygon":
+		xs, _, err := svgNumbers(n.attr("points"))
+		if err != nil {
+			return nil, false, err
+		}
+		if len(xs) < 6 || len(xs)%2 != 0 {
+			return nil, false, errors.New("<polygon> needs three or more points")
+		}
+		if len(xs) == 6 {
+			return &shape.Triangle{X1: xs[0], Y1: xs[1], X2: xs[2], Y2: xs[3], X3: xs[4], Y3: xs[5]}, false, nil
+		}
+		p := &shape.Polygon{Order: len(xs) / 2}
+		for i := 0; i < len(xs); i += 2 {
+			p.X = append(p.X, xs[i])
+			p.Y = append(p.Y, xs[i+1])
+		}
+		return p, false, nil
+	case "ellipse":
+		xs, err := svgAttrNumbers(n, "cx", "cy", "rx", "ry")
+		if err != nil {
+			return nil, false, err
+		}
+		if len(rotate) == 0 {
+			return &shape.Ellipse{X: svgInt(xs[0]), Y: svgInt(xs[1]), Rx: svgInt(xs[2]), Ry: svgInt(xs[3])}, false, nil
+		}
+		return &shape.RotatedEllipse{X: xs[0], Y: xs[1], Rx: xs[2], Ry: xs[3], Angle: rotate[0]}, false, nil
+	case "rect":
+		xs, err := svgAttrNumbers(n, "x", "y", "width", "height")
+		if err != nil {
+			return nil, false, err
+		}
+		w, h := svgInt(xs[2]), svgInt(xs[3])
+		if len(rotate) == 0 {
+			x, y := svgInt(xs[0]), svgInt(xs[1])
+			return &shape.Rectangle{X1: x, Y1: y, X2: x + w - 1, Y2: y + h - 1}, false, nil
+		}
+		if len(rotate) != 3 {
+			return nil, false, errors.New("<rect> needs a rotation about its center")
+		}
+		return &shape.RotatedRectangle{
+			X: svgInt(rotate[1]), Y: svgInt(rotate[2]), Sx: w, Sy: h, Angle: svgInt(rotate[0])}, false, nil
+	case "path":
+		xs, ops, err := svgNumbers(n.attr("d"))
+		if err != nil {
+			return nil, false, err
+		}
+		width, err := strconv.ParseFloat(n.attr("stroke-width"), 64)
+		if err != nil {
+			return nil, false, fmt.Errorf("<path> stroke-width: %v", err)
+		}
+		switch {
+		case ops == "ML" && len(xs) == 4:
+			return &shape.Line{X1: xs[0], Y1: xs[1], X2: xs[2], Y2: xs[3], Width: width}, true, nil
+		case ops == "MQ" && len(xs) == 6:
+			return &shape.Quadratic{X1: xs[0], Y1: xs[1], X2: xs[2], Y2: xs[3], X3: xs[4], Y3: xs[5], Width: width}, true, nil
+		case ops == "MC" && len(xs) == 8:
+			return &shape.Cubic{X1: xs[0], Y1: xs[1], X2: xs[2], Y2: xs[3], X3: xs[4], Y3: xs[5], X4: xs[6], Y4: xs[7], Width: width}, true, nil
+		}
+		return nil, false, fmt.Errorf("unsupported path: %q", n.attr("d"))
+	}
+	return nil, false, fmt.Errorf("unsupported element: <%s>", n.XMLName.Local)
+}
+
+// svgReader rebuilds a Result from the elements of an SVG document.
+type svgReader struct {
+	result  *Result
+	skipped map[string]int
+}
+
+// shapes adds the shapes inside the group, in the paint of the group.
+func (r *svgReader) shapes(g *svgNode, paint svgPaint) error {
+	for i := range g.Children {
+		n := &g.Children[i]
+		p := paint.with(n)
+		switch n.XMLName.Local {
+		case "g":
+			if err := r.shapes(n, p); err != nil {
+				return err
+			}
+			continue
+		case "use":
+			// copies of symmetry and tiling, which the new run draws
+			continue
+		}
+		if n.attr("mask") != "" {
+			// sprites are masks of images which are not in the result
+			r.skipped["sprite"]++
+			continue
+		}
+		s, stroked, err := svgShape(n)
+		if err != nil {
+			return err
+		}
+		c, err := p.color(stroked)
+		if err != nil {
+			return err
+		}
+		blend, err := p.blend()
+		if err != nil {
+			return err
+		}
+		r.result.Shapes = append(r.result.Shapes, ResultShape{s, c, blend})
+	}
+	return nil
+}
+
+// svgGradient returns the background gradient whose elements are drawn
+// with fill, in output coordinates at the scale, from the definitions.
+func svgGradient(fill string, defs map[string]*svgNode, scale float64) (*Gradient, error) {
+	stops := func(id string) ([]Color, error) {
+		n, ok := defs[id]
+		if !ok || len(n.Children) != 2 {
+			return nil, fmt.Errorf("gradient #%s not found", id)
+		}
+		return []Color{MakeHexColor(n.Children[0].attr("stop-color")), MakeHexColor(n.Children[1].attr("stop-color"))}, nil
+	}
+	plane := func(xs []float64) {
+		for i := range xs {
+			xs[i] = xs[i]/scale - 0.5
+		}
+	}
+	switch fill {
+	case "url(#bg)":
+		colors, err := stops("bg")
+		if err != nil {
+			return nil, err
+		}
+		n := defs["bg"]
+		if n.XMLName.Local == "radialGradient" {
+			xs, err := svgAttrNumbers(n, "cx", "cy", "r")
+			if err != nil {
+				return nil, err
+			}
+			r := xs[2] / scale
+			plane(xs[:2])
+			return &Gradient{Mode: GradientRadial, X1: xs[0], Y1: xs[1], R: r, Colors: colors}, nil
+		}
+		xs, err := svgAttrNumbers(n, "x1", "y1", "x2", "y2")
+		if err != nil {
+			return nil, err
+		}
+		plane(xs)
+		return &Gradient{Mode: GradientLinear, X1: xs[0], Y1: xs[1], X2: xs[2], Y2: xs[3], Colors: colors}, nil
+	case "url(#bg-top)":
+		top, err := stops("bg-top")
+		if err != nil {
+			return nil, err
+		}
+		bottom, err := stops("bg-bottom")
+		if err != nil {
+			return nil, err
+		}
+		fade, ok := defs["bg-fade"]
+		if !ok {
+			return nil, errors.New("gradient #bg-fade not found")
+		}
+		xs, err := svgAttrNumbers(defs["bg-top"], "x1", "x2")
+		if err != nil {
+			return nil, err
+		}
+		ys, err := svgAttrNumbers(fade, "y1", "y2")
+		if err != nil {
+			return nil, err
+		}
+		plane(xs)
+		plane(ys)
+		return &Gradient{Mode: GradientQuad, X1: xs[0], Y1: ys[0], X2: xs[1], Y2: ys[1], Colors: append(top, bottom...)}, nil
+	}
+	return nil, fmt.Errorf("unsupported background: %q", fill)
+}
+
+// ParseSVG reads a Result from the SVG output of a run. The size of the
+// plane is that of the viewBox at the scale of the group of the shapes.
+// Shapes come back as the elements they were written as, so regular
+// polygons, stars, brush strokes and stamps are polygons, and sprites are
+// left out. A run started from an image cannot be read back, as the image
+// is not part of a Result.
+func ParseSVG(data []byte) (*Result, error) {
+	root := svgNode{}
+	if err := xml.Unmarshal(data, &root); err != nil {
+		return nil, err
+	}
+	if root.XMLName.Local != "svg" {
+		return nil, errors.New("not an SVG document")
+	}
+	view, _, err := svgNumbers(root.attr("viewBox"))
+	if err != nil || len(view) != 4 {
+		return nil, errors.New("<svg> needs a viewBox")
+	}
+	r := &svgReader{result: &Result{}, skipped: map[string]int{}}
+	defs := map[string]*svgNode{}
+	background := ""
+	scale := 0.0
+	for i := range root.Children {
+		n := &root.Children[i]
+		switch n.XMLName.Local {
+		case "defs":
+			for j := range n.Children {
+				defs[n.Children[j].attr("id")] = &n.Children[j]
+			}
+		case "image":
+			return nil, errors.New("the run started from an image, which cannot be read back")
+		case "rect":
+			if background == "" {
+				background = n.attr("fill")
+			}
+		case "g":
+			if scale != 0 {
+				return nil, errors.New("more than one group of shapes")
+			}
+			xs, op, err := svgNumbers(n.attr("transform"))
+			if err != nil || !strings.HasPrefix(op, "scale") || len(xs) == 0 || xs[0] <= 0 {
+				return nil, errors.New("the group of shapes needs a scale")
+			}
+			scale = xs[0]
+			if err := r.shapes(n, svgPaint{}.with(n)); err != nil {
+				return nil, err
+			}
+		}
+	}
+	if scale == 0 {
+		return nil, errors.New("no group of shapes; not the SVG output of primitive")
+	}
+	result := r.result
+	// the output size is rounded down from the plane at the scale
+	result.Width = int(math.Ceil(view[2]/scale - 1e-6))
+	result.Height = int(math.Ceil(view[3]/scale - 1e-6))
+	switch {
+	case background == "":
+		// transparent
+	case strings.HasPrefix(background, "url("):
+		g, err := svgGradient(background, defs, scale)
+		if err != nil {
+			return nil, err
+		}
+		result.Gradient = g
+		result.Background = averageColor(g.Colors)
+	default:
+		result.Background = MakeHexColor(background)
+	}
+	for name, count := range r.skipped {
+		warn("left out %d %s shapes, which cannot be read back from SVG\n", count, name)
+	}
+	return result, nil
+}
+
+// averageColor returns the mean of the colors.
+func averageColor(colors []Color) Color {
+	var r, g, b, a int
+	for _, c := range colors {
+		r += c.R
+		g += c.G
+		b += c.B
+		a += c.A
+	}
+	n := len(colors)
+	return Color{r / n, g / n, b / n, a / n}
+}
+
+// svgInt returns the integer field of a shape written as the number.
+func svgInt(x float64) int {
+	return int(math.Round(x))
+}
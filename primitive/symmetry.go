@@ -0,0 +1,226 @@
+package primitive
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+
+	"github.com/fogleman/gg"
+	"github.com/laramiel/primitive/primitive/shape"
+)
+
+type SymmetryMode int
+
+const (
+	SymmetryNone       SymmetryMode = iota
+	SymmetryHorizontal              // mirrored left to right
+	SymmetryVertical                // mirrored top to bottom
+	SymmetryQuad                    // mirrored both ways
+	SymmetryRotational              // N copies rotated about the center
+)
+
+// Symmetry describes the copies of each shape which are drawn and scored
+// along with it, so that the output is symmetric.
+type Symmetry struct {
+	Mode SymmetryMode
+	N    int
+}
+
+// ParseSymmetry parses the -symmetry flag: "horizontal", "vertical",
+// "quad" or "rotational:N".
+func ParseSymmetry(value string) (*Symmetry, error) {
+	switch value {
+	case "", "none":
+		return nil, nil
+	case "horizontal":
+		return &Symmetry{SymmetryHorizontal, 2}, nil
+	case "vertical":
+		return &Symmetry{SymmetryVertical, 2}, nil
+	case "quad":
+		return &Symmetry{SymmetryQuad, 4}, nil
+	}
+	if strings.HasPrefix(value, "rotational:") {
+		n, err := strconv.Atoi(strings.TrimPrefix(value, "rotational:"))
+		if err != nil || n < 2 {
+			return nil, fmt.Errorf("invalid rotational symmetry: %s", value)
+		}
+		return &Symmetry{SymmetryRotational, n}, nil
+	}
+	return nil, fmt.Errorf("unrecognized symmetry: %s", value)
+}
+
+// affine is the transform x' = a*x + b*y + c, y' = d*x + e*y + f.
+type affine [6]float64
+
+func (t affine) apply(x, y float64) (float64, float64) {
+	return t[0]*x + t[1]*y + t[2], t[3]*x + t[4]*y + t[5]
+}
+
+func (t affine) invert() affine {
+	det := t[0]*t[4] - t[1]*t[3]
+	a, b := t[4]/det, -t[1]/det
+	d, e := -t[3]/det, t[0]/det
+	return affine{a, b, -a*t[2] - b*t[5], d, e, -d*t[2] - e*t[5]}
+}
+
+// transforms returns the transforms of the copies of a shape on a w by h
+// plane, starting with the identity.
+func (s *Symmetry) transforms(w, h int) []affine {
+	fw, fh := float64(w), float64(h)
+	result := []affine{{1, 0, 0, 0, 1, 0}}
+	switch s.Mode {
+	case SymmetryHorizontal:
+		result = append(result, affine{-1, 0, fw, 0, 1, 0})
+	case SymmetryVertical:
+		result = append(result, affine{1, 0, 0, 0, -1, fh})
+	case SymmetryQuad:
+		result = append(result,
+			affine{-1, 0, fw, 0, 1, 0},
+			affine{1, 0, 0, 0, -1, fh},
+			affine{-1, 0, fw, 0, -1, fh})
+	case SymmetryRotational:
+		cx, cy := fw/2, fh/2
+		for i := 1; i < s.N; i++ {
+			theta := 2 * math.Pi * float64(i) / float64(s.N)
+			cos, sin := math.Cos(theta), math.Sin(theta)
+			result = append(result, affine{
+				cos, -sin, cx - cos*cx + sin*cy,
+				sin, cos, cy - sin*cx - cos*cy})
+		}
+	}
+	return result
+}
+
+func (t affine) mul(u affine) affine {
+	return affine{
+		t[0]*u[0] + t[1]*u[3], t[0]*u[1] + t[1]*u[4], t[0]*u[2] + t[1]*u[5] + t[2],
+		t[3]*u[0] + t[4]*u[3], t[3]*u[1] + t[4]*u[4], t[3]*u[2] + t[4]*u[5] + t[5]}
+}
+
+// expand returns the union of the scanlines of a shape and of its copies.
+// Where copies overlap, the higher coverage wins, so that no pixel is
+// drawn or scored twice.
+func (s *Symmetry) expand(buf *lineBuffer, lines []shape.Scanline) []shape.Scanline {
+	w, h := buf.W, buf.H
+	if len(lines) == 0 {
+		return lines
+	}
+	sx0, sy0, sx1, sy1 := w, h, -1, -1
+	for _, line := range lines {
+		i := line.Y * w
+		for x := line.X1; x <= line.X2; x++ {
+			buf.Src[i+x] = line.Alpha
+		}
+		sx0, sx1 = minInt(sx0, line.X1), maxInt(sx1, line.X2)
+		sy0, sy1 = minInt(sy0, line.Y), maxInt(sy1, line.Y)
+	}
+	for _, t := range s.transforms(w, h) {
+		// bounds of the copy
+		x0, y0 := math.Inf(1), math.Inf(1)
+		x1, y1 := math.Inf(-1), math.Inf(-1)
+		for _, p := range [4][2]int{{sx0, sy0}, {sx1 + 1, sy0}, {sx0, sy1 + 1}, {sx1 + 1, sy1 + 1}} {
+			x, y := t.apply(float64(p[0]), float64(p[1]))
+			x0, y0 = math.Min(x0, x), math.Min(y0, y)
+			x1, y1 = math.Max(x1, x), math.Max(y1, y)
+		}
+		tx0, ty0 := maxInt(int(math.Floor(x0)), 0), maxInt(int(math.Floor(y0)), 0)
+		tx1, ty1 := minInt(int(math.Ceil(x1)), w-1), minInt(int(math.Ceil(y1)), h-1)
+		inv := t.invert()
+		for y := ty0; y <= ty1; y++ {
+			for x := tx0; x <= tx1; x++ {
+				fx, fy := inv.apply(float64(x)+0.5, float64(y)+0.5)
+				ix, iy := int(math.Floor(fx)), int(math.Floor(fy))
+				if ix < sx0 || ix > sx1 || iy < sy0 || iy > sy1 {
+					continue
+				}
+				buf.add(x, y, buf.Src[iy*w+ix])
+			}
+		}
+	}
+	for y := sy0; y <= sy1; y++ {
+		for x := sx0; x <= sx1; x++ {
+			buf.Src[y*w+x] = 0
+		}
+	}
+	return buf.lines()
+}
+
+// drawCopies draws the shape once for each of the transforms.
+func drawCopies(dc *gg.Context, sh shape.Shape, scale float64, copies []affine) {
+	for _, t := range copies {
+		// t is a rotation, possibly after a reflection in the x axis
+		dc.Push()
+		dc.Translate(t[2], t[5])
+		dc.Rotate(math.Atan2(t[3], t[0]))
+		if t[0]*t[4]-t[1]*t[3] < 0 {
+			dc.Scale(1, -1)
+		}
+		sh.Draw(dc, scale)
+		dc.Pop()
+	}
+}
+
+// svgCopies returns the SVG of the shape with the given id followed by a
+// <use> element for each of the transforms after the first, which must be
+// the identity.
+func svgCopies(id, svg string, copies []affine) []string {
+	lines := []string{fmt.Sprintf("<g id=\"%s\">%s</g>", id, svg)}
+	for _, t := range copies[1:] {
+		lines = append(lines, fmt.Sprintf(
+			"<use href=\"#%s\" transform=\"matrix(%s)\" />",
+			id, svgMatrix(t)))
+	}
+	return lines
+}
+
+// svgMatrix formats the transform as the arguments of an SVG matrix, to
+// six significant digits since rotations magnify any error in the
+// coefficients.
+func svgMatrix(t affine) string {
+	var s []string
+	for _, x := range [6]float64{t[0], t[3], t[1], t[4], t[2], t[5]} {
+		if math.Abs(x) < 1e-9 {
+			x = 0
+		}
+		s = append(s, strconv.FormatFloat(x, 'g', 6, 64))
+	}
+	return strings.Join(s, " ")
+}
+
+// Symmetrize returns the image averaged with its copies, so that the
+// target is itself symmetric.
+func (s *Symmetry) Symmetrize(im *image.RGBA) *image.RGBA {
+	size := im.Bounds().Size()
+	w, h := size.X, size.Y
+	dst := image.NewRGBA(im.Bounds())
+	var invs []affine
+	for _, t := range s.transforms(w, h) {
+		invs = append(invs, t.invert())
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var r, g, b, a, n int
+			for _, inv := range invs {
+				fx, fy := inv.apply(float64(x)+0.5, float64(y)+0.5)
+				ix, iy := int(math.Floor(fx)), int(math.Floor(fy))
+				if ix < 0 || ix >= w || iy < 0 || iy >= h {
+					continue
+				}
+				i := im.PixOffset(ix, iy)
+				r += int(im.Pix[i])
+				g += int(im.Pix[i+1])
+				b += int(im.Pix[i+2])
+				a += int(im.Pix[i+3])
+				n++
+			}
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(r / n)
+			dst.Pix[i+1] = uint8(g / n)
+			dst.Pix[i+2] = uint8(b / n)
+			dst.Pix[i+3] = uint8(a / n)
+		}
+	}
+	return dst
+}
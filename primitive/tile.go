@@ -0,0 +1,49 @@
+package primitive
+
+import "github.com/laramiel/primitive/primitive/shape"
+
+// wrapLines folds the scanlines of a shape rasterized in tile mode back onto
+// the plane, as if it were a torus. Where the shape overlaps itself once
+// wrapped, the higher coverage wins.
+func wrapLines(buf *lineBuffer, lines []shape.Scanline) []shape.Scanline {
+	w, h := buf.W, buf.H
+	inside := true
+	for _, line := range lines {
+		if line.Y < 0 || line.Y >= h || line.X1 < 0 || line.X2 >= w {
+			inside = false
+			break
+		}
+	}
+	if inside {
+		return lines
+	}
+	for _, line := range lines {
+		y := (line.Y + h) % h
+		for x := line.X1; x <= line.X2; x++ {
+			buf.add((x+w)%w, y, line.Alpha)
+		}
+	}
+	return buf.lines()
+}
+
+// tileOffsets returns the translations of the copies of a shape needed to
+// draw it wrapped onto a w by h plane, starting with the identity.
+func tileOffsets(lines []shape.Scanline, w, h int) []affine {
+	var cells [3][3]bool
+	for _, line := range lines {
+		j := (line.Y+h)/h - 1
+		for i := (line.X1+w)/w - 1; i <= (line.X2+w)/w-1; i++ {
+			cells[j+1][i+1] = true
+		}
+	}
+	result := []affine{{1, 0, 0, 0, 1, 0}}
+	for j := -1; j <= 1; j++ {
+		for i := -1; i <= 1; i++ {
+			if (i != 0 || j != 0) && cells[j+1][i+1] {
+				// a shape in cell i, j is drawn again in the plane
+				result = append(result, affine{1, 0, float64(-i * w), 0, 1, float64(-j * h)})
+			}
+		}
+	}
+	return result
+}
@@ -1,6 +1,8 @@
 package primitive
 
 import (
+	"bytes"
+	"compress/gzip"
 	"fmt"
 	"image"
 	"image/color"
@@ -14,6 +16,8 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+
+	"github.com/nfnt/resize"
 )
 
 func LoadImage(path string) (image.Image, error) {
@@ -46,6 +50,40 @@ func SaveFile(path, contents string) error {
 	}
 }
 
+// SaveGzipFile writes the contents compressed with gzip, as for .svgz.
+func SaveGzipFile(path, contents string) error {
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	w := gzip.NewWriter(file)
+	if _, err := w.Write([]byte(contents)); err != nil {
+		return err
+	}
+	return w.Close()
+}
+
+// GzipSize returns the size of the contents compressed with gzip, as
+// when they are served compressed.
+func GzipSize(contents string) int {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(contents))
+	w.Close()
+	return buf.Len()
+}
+
+// gunzip returns the data decompressed with gzip, as read from .svgz.
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return ioutil.ReadAll(r)
+}
+
 func SavePNG(path string, im image.Image) error {
 	file, err := os.Create(path)
 	if err != nil {
@@ -178,6 +216,11 @@ func imageToRGBA(src image.Image) *image.RGBA {
 	return dst
 }
 
+// resizeRGBA returns the image stretched to w by h pixels.
+func resizeRGBA(src image.Image, w, h int) *image.RGBA {
+	return imageToRGBA(resize.Resize(uint(w), uint(h), src, resize.Bilinear))
+}
+
 func copyRGBA(src *image.RGBA) *image.RGBA {
 	dst := image.NewRGBA(src.Bounds())
 	copy(dst.Pix, src.Pix)
@@ -18,6 +18,14 @@ type Worker struct {
 	Score       float64
 	Counter     int
 	ColorPicker ColorPicker // Picks the best color for the input scanlines
+	Symmetry    *Symmetry   // Copies scored along with each shape, if any
+	Blend       BlendMode   // How shapes are blended with the current image
+	// linear-light copies of the images, when the model is linear
+	targetLinear  *linearRGBA
+	currentLinear *linearRGBA
+	bufferLinear  *linearRGBA
+	mask          []uint32 // coverage allowed at each pixel, if any
+	buffer        *lineBuffer
 }
 
 func NewWorker(target *image.RGBA, seed int64, picker ColorPicker) *Worker {
@@ -53,15 +61,29 @@ func (worker *Worker) Init(current *image.RGBA, score float64) {
 
 func (worker *Worker) Energy(shape shape.Shape, alpha int) float64 {
 	worker.Counter++
-	lines := shape.Rasterize(&worker.RC)
+	lines := worker.rasterize(shape)
 	// worker.Heatmap.Add(lines)
-	color := worker.ColorPicker.Select(worker.Target, worker.Current, lines, alpha)
-	copyLines(worker.Buffer, worker.Current, lines)
-	drawLines(worker.Buffer, color, lines)
+	var color Color
+	if worker.currentLinear != nil {
+		color = selectColorLinear(worker.ColorPicker, worker.Target, worker.Current,
+			worker.targetLinear, worker.currentLinear, lines, alpha)
+		copyLinesLinear(worker.bufferLinear, worker.currentLinear, lines)
+		drawLinesLinear(worker.Buffer, worker.bufferLinear, color, lines)
+	} else {
+		color = selectColor(worker.ColorPicker, worker.Blend, worker.Target, worker.Current, lines, alpha)
+		copyLines(worker.Buffer, worker.Current, lines)
+		drawLinesBlend(worker.Buffer, color, lines, worker.Blend)
+	}
 	energy := differencePartial(worker.Target, worker.Current, worker.Buffer, worker.Score, lines)
 	return energy
 }
 
+// rasterize returns the scanlines of the shape, along with those of its
+// copies when symmetry or tiling is enabled, masked by the opaque area.
+func (worker *Worker) rasterize(s shape.Shape) []shape.Scanline {
+	return rasterizeCopies(s, &worker.RC, &worker.buffer, worker.Symmetry, worker.mask)
+}
+
 func (worker *Worker) BestHillClimbState(factory shape.ShapeFactory, a, n, age, m int) *State {
 	var bestEnergy float64
 	var bestState *State